@@ -0,0 +1,88 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+// A target whose do script calls redo-always has a special prerequisite, which records
+// the RunID of the redo invocation that last built it. The target is out of date in every
+// other invocation, so it is rebuilt at most once per run.
+
+// PutAlways records that the target must always be rebuilt.
+func (f *File) PutAlways() error {
+	return f.Put(f.alwaysKey(), RunID)
+}
+
+// MustAlwaysRebuild returns a boolean denoting whether the target must always be rebuilt
+// and has not been rebuilt in the current run.
+func (f *File) MustAlwaysRebuild() bool {
+	var runID string
+	found, err := f.Get(f.alwaysKey(), &runID)
+	if err != nil {
+		panic(err)
+	}
+	return found && (runID != RunID || RunID == "")
+}
+
+// DeleteAlways removes the database record.
+func (f *File) DeleteAlways() error {
+	return f.Delete(f.alwaysKey())
+}
+
+// deleteStaleAlways removes the database record unless it was created in the current run.
+func (f *File) deleteStaleAlways() error {
+	var runID string
+	found, err := f.Get(f.alwaysKey(), &runID)
+	if err != nil || !found || runID == RunID {
+		return err
+	}
+	return f.DeleteAlways()
+}
+
+// isCurrentAfterAlways is like IsCurrent, except that the prerequisites of an out of date file
+// that must always be rebuilt are rebuilt and the file is checked again.
+func (f *File) isCurrentAfterAlways() (bool, error) {
+	isCurrent, err := f.IsCurrent()
+	if err != nil || isCurrent {
+		return isCurrent, err
+	}
+
+	rebuilt, err := f.redoAlways(make(map[Hash]bool))
+	if err != nil || !rebuilt {
+		return false, err
+	}
+
+	return f.IsCurrent()
+}
+
+// redoAlways rebuilds the file's ifchange prerequisites that must always be rebuilt,
+// whether they are direct prerequisites or prerequisites of prerequisites.
+// Such a prerequisite may well be unchanged after it is rebuilt, especially if it has a stamp,
+// so the file should be checked again afterwards. The return value denotes whether anything was rebuilt.
+func (f *File) redoAlways(visited map[Hash]bool) (rebuilt bool, err error) {
+
+	if visited[f.FullPathHash] {
+		return false, nil
+	}
+	visited[f.FullPathHash] = true
+
+	prerequisites, err := f.PrerequisiteFiles(IFCHANGE)
+	if err != nil {
+		return false, err
+	}
+
+	for _, prerequisite := range prerequisites {
+		if prerequisite.MustAlwaysRebuild() {
+			if err := prerequisite.RedoIfChange(f); err != nil {
+				return false, err
+			}
+			rebuilt = true
+		} else if ok, err := prerequisite.redoAlways(visited); err != nil {
+			return false, err
+		} else if ok {
+			rebuilt = true
+		}
+	}
+
+	return rebuilt, nil
+}
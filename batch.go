@@ -0,0 +1,229 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"sort"
+	"strings"
+)
+
+// A Batch holds changes that are written to a database together, with DB.Write.
+// Later changes to a key replace earlier ones.
+type Batch struct {
+	ops  []batchOp
+	keys map[string]int // index of each key's op
+
+	// owner is the file whose records the batch changes, if it is written by a transaction.
+	owner Hash
+}
+
+type batchOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// Put adds a change that stores value under key.
+func (b *Batch) Put(key string, value []byte) {
+	b.add(batchOp{key: key, value: value})
+}
+
+// Delete adds a change that removes the value stored under key.
+func (b *Batch) Delete(key string) {
+	b.add(batchOp{key: key, delete: true})
+}
+
+func (b *Batch) add(op batchOp) {
+	if b.keys == nil {
+		b.keys = make(map[string]int)
+	}
+
+	if i, ok := b.keys[op.key]; ok {
+		b.ops[i] = op
+		return
+	}
+
+	b.keys[op.key] = len(b.ops)
+	b.ops = append(b.ops, op)
+}
+
+// Len returns the number of changes in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// lookup returns the change to key, if any.
+func (b *Batch) lookup(key string) (batchOp, bool) {
+	if i, ok := b.keys[key]; ok {
+		return b.ops[i], true
+	}
+	return batchOp{}, false
+}
+
+// apply makes the changes in the batch one at a time.
+// It is used by databases that have no better way to write a batch and to recover
+// a batch that was interrupted.
+func (b *Batch) apply(db DB) error {
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = db.Delete(op.key)
+		} else {
+			err = db.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// txDB is a database whose changes are held in a batch until they are committed.
+// Reads see the pending changes.
+type txDB struct {
+	DB
+	batch Batch
+}
+
+func (tx *txDB) Put(key string, value []byte) error {
+	if len(key) == 0 {
+		return NullKeyErr
+	}
+	tx.batch.Put(key, value)
+	return nil
+}
+
+// Delete does not add a change for a key that does not exist, to keep batches small.
+func (tx *txDB) Delete(key string) error {
+	if len(key) == 0 {
+		return NullKeyErr
+	}
+
+	if _, ok := tx.batch.lookup(key); !ok {
+		if _, found, err := tx.DB.Get(key); err != nil || !found {
+			return err
+		}
+	}
+
+	tx.batch.Delete(key)
+	return nil
+}
+
+func (tx *txDB) Get(key string) ([]byte, bool, error) {
+	if op, ok := tx.batch.lookup(key); ok {
+		return op.value, !op.delete, nil
+	}
+	return tx.DB.Get(key)
+}
+
+func (tx *txDB) GetRecords(prefix string) ([]Record, error) {
+	if len(prefix) == 0 {
+		return nil, NullPrefixErr
+	}
+
+	records, err := tx.DB.GetRecords(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return tx.merge(prefix, records), nil
+}
+
+func (tx *txDB) GetAllRecords() ([]Record, error) {
+	records, err := tx.DB.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+	return tx.merge("", records), nil
+}
+
+func (tx *txDB) GetKeys(prefix string) ([]string, error) {
+	records, err := tx.GetRecords(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, len(records))
+	for i, rec := range records {
+		out[i] = rec.Key
+	}
+	return out, nil
+}
+
+func (tx *txDB) GetValues(prefix string) ([][]byte, error) {
+	records, err := tx.GetRecords(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, len(records))
+	for i, rec := range records {
+		out[i] = rec.Value
+	}
+	return out, nil
+}
+
+// merge applies the pending changes to the records whose keys begin with prefix.
+func (tx *txDB) merge(prefix string, records []Record) []Record {
+	var out []Record
+	seen := make(map[string]bool)
+
+	for _, rec := range records {
+		seen[rec.Key] = true
+		if op, ok := tx.batch.lookup(rec.Key); !ok {
+			out = append(out, rec)
+		} else if !op.delete {
+			out = append(out, Record{Key: rec.Key, Value: op.value})
+		}
+	}
+
+	for _, op := range tx.batch.ops {
+		if !op.delete && !seen[op.key] && strings.HasPrefix(op.key, prefix) {
+			out = append(out, Record{Key: op.key, Value: op.value})
+		}
+	}
+
+	sort.Sort(recordsByKey(out))
+
+	return out
+}
+
+type recordsByKey []Record
+
+func (a recordsByKey) Len() int           { return len(a) }
+func (a recordsByKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a recordsByKey) Less(i, j int) bool { return a[i].Key < a[j].Key }
+
+// Begin starts a transaction on the file's database. Until the transaction is committed
+// or rolled back, changes made through the file are held and reads through the file see them.
+// Transactions do not nest and changes made through other files are not included.
+func (f *File) Begin() {
+	if _, ok := f.db.(*txDB); ok {
+		panic("nested transaction for " + f.Path)
+	}
+	f.db = &txDB{DB: f.db}
+}
+
+// Commit writes the changes made in the transaction together and ends it.
+func (f *File) Commit() error {
+	tx, ok := f.db.(*txDB)
+	if !ok {
+		return nil
+	}
+
+	f.db = tx.DB
+	tx.batch.owner = f.PathHash
+
+	f.Debug("@Commit %d changes\n", tx.batch.Len())
+
+	return f.db.Write(&tx.batch)
+}
+
+// Rollback discards the changes made in the transaction, if any, and ends it.
+// It has no effect after Commit.
+func (f *File) Rollback() {
+	if tx, ok := f.db.(*txDB); ok {
+		f.db = tx.DB
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Where are build logs kept?
+	LOG_DIR = "log"
+
+	// How long is the output of a do script waited for, once the script has exited?
+	// Background processes started by the script may hold its standard error open indefinitely.
+	LOG_DRAIN_DELAY = 100 * time.Millisecond
+)
+
+// A BuildLog holds the standard error output of the last run of a target's do script.
+//
+// A build log is a file, named for the target's PathHash, in the .redo/log directory.
+// The first line is a JSON encoded header and the rest is the output, as written.
+// Since the output of nested redo commands goes to the do script's standard error,
+// the log includes the output of the prerequisites built by the do script.
+type BuildLog struct {
+	Path    string    `json:"path"`   // target path, relative to the root directory.
+	RunID   string    `json:"run_id"` // the redo invocation that built the target.
+	Start   time.Time `json:"start"`
+	Pending string    `json:"pending,omitempty"` // identifies the targets whose do scripts the build was nested in.
+	Output  []byte    `json:"-"`
+}
+
+// NestedIn returns true if the build was run by the do script of f, or one of its prerequisites,
+// in which case its output is included in the log of f.
+func (log *BuildLog) NestedIn(f *File) bool {
+	return strings.Contains(log.Pending, f.pendingID())
+}
+
+func (f *File) buildLogPath() string {
+	return filepath.Join(f.RedoDir(), LOG_DIR, string(f.PathHash))
+}
+
+// newBuildLog creates the target's build log, replacing the previous one, and writes its header.
+// The caller writes the output and closes the file.
+func (f *File) newBuildLog() (*os.File, error) {
+	path := f.buildLogPath()
+	if err := os.MkdirAll(filepath.Dir(path), DIR_PERM); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(BuildLog{Path: f.Path, RunID: RunID, Start: time.Now(), Pending: os.Getenv("REDO_PENDING")})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// A logPipe is the standard error of a do script. Its output is copied to redo's standard error and to the build log.
+//
+// The script is given a pipe, rather than a writer that os/exec would copy from, because exec.Cmd.Wait
+// waits for such a copy to complete and so for every background process that the script starts to exit.
+type logPipe struct {
+	*os.File // the write end, which is given to the script.
+	mark     []byte
+	drained  chan struct{} // closed when the mark, and so everything written before it, has been copied.
+	done     chan struct{} // closed when every writer has closed the pipe.
+}
+
+// newLogPipe returns a pipe whose output is copied to redo's standard error and to the build log,
+// which is closed when the copy completes.
+func newLogPipe(log *os.File) (*logPipe, error) {
+	mark := make([]byte, 16)
+	if _, err := rand.Read(mark); err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &logPipe{File: w, mark: mark, drained: make(chan struct{}), done: make(chan struct{})}
+
+	go func() {
+		p.copy(io.MultiWriter(os.Stderr, log), r)
+		r.Close()
+		log.Close()
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// copy copies the output of the pipe to w, except for the mark, which it reports by closing p.drained.
+// The mark may be split across reads, so a trailing partial mark is held back until the next read.
+func (p *logPipe) copy(w io.Writer, r io.Reader) {
+	defer func() {
+		select {
+		case <-p.drained:
+		default:
+			close(p.drained)
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	var held []byte
+
+	for {
+		n, err := r.Read(buf)
+		data := append(held, buf[:n]...)
+		held = nil
+
+		select {
+		case <-p.drained:
+		default:
+			if i := bytes.Index(data, p.mark); i >= 0 {
+				data = append(data[:i], data[i+len(p.mark):]...)
+				close(p.drained)
+			} else if err == nil {
+				k := partialSuffix(data, p.mark)
+				data, held = data[:len(data)-k], append([]byte(nil), data[len(data)-k:]...)
+			}
+		}
+
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// partialSuffix returns the length of the longest suffix of data that is a proper prefix of mark.
+func partialSuffix(data, mark []byte) int {
+	for k := len(mark) - 1; k > 0; k-- {
+		if len(data) >= k && bytes.Equal(data[len(data)-k:], mark[:k]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// Close closes redo's copy of the write end, once the script has exited, and waits for the script's output
+// to be copied. Redo writes a mark before it closes the pipe, so everything the script wrote precedes it,
+// and always waits for the mark to be copied. The output of background processes that keep the pipe open
+// is not waited for beyond LOG_DRAIN_DELAY, but continues to be copied for as long as redo runs.
+func (p *logPipe) Close() error {
+	_, err := p.File.Write(p.mark)
+	if err2 := p.File.Close(); err == nil {
+		err = err2
+	}
+
+	<-p.drained
+
+	select {
+	case <-p.done:
+	case <-time.After(LOG_DRAIN_DELAY):
+	}
+
+	return err
+}
+
+// BuildLog returns the target's build log. The second return value is false if there is none.
+func (f *File) BuildLog() (*BuildLog, bool, error) {
+	b, err := ioutil.ReadFile(f.buildLogPath())
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil, false, fmt.Errorf("%s: truncated build log", f.buildLogPath())
+	}
+
+	var log BuildLog
+	if err := json.Unmarshal(b[:i], &log); err != nil {
+		return nil, false, fmt.Errorf("%s: %s", f.buildLogPath(), err)
+	}
+
+	log.Output = b[i+1:]
+
+	return &log, true, nil
+}
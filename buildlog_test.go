@@ -0,0 +1,82 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// Output that is in the pipe when it is closed is copied, however long that takes.
+func TestLogPipeDrain(t *testing.T) {
+	log, err := ioutil.TempFile("", "redo-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(log.Name())
+
+	// A standard error that is slow to read.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	go func() {
+		time.Sleep(4 * LOG_DRAIN_DELAY)
+		ioutil.ReadAll(r)
+	}()
+
+	p, err := newLogPipe(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More than both pipes hold, so some of it is still in the log pipe when it is closed.
+	want := bytes.Repeat([]byte("output\n"), 16384)
+	if _, err := p.Write(want); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(log.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("want %d bytes in the log, got %d", len(want), len(got))
+	}
+}
+
+// The mark is removed from the output, even when it is read in pieces.
+func TestLogPipeMark(t *testing.T) {
+	p := &logPipe{mark: []byte("<mark>")}
+
+	for _, input := range []string{"before <mark>after", "before <ma<mark>after<mark>", "<mark>"} {
+		p.drained = make(chan struct{})
+
+		var out bytes.Buffer
+		p.copy(&out, iotest.OneByteReader(strings.NewReader(input)))
+
+		want := strings.Replace(input, "<mark>", "", 1)
+		if got := out.String(); got != want {
+			t.Errorf("%q: want %q, got %q", input, want, got)
+		}
+	}
+}
@@ -73,6 +73,14 @@ func (f *File) mustRebuildKey() string {
 	return f.makeKey("REBUILD")
 }
 
+func (f *File) stampKey() string {
+	return f.makeKey("STAMP")
+}
+
+func (f *File) alwaysKey() string {
+	return f.makeKey("ALWAYS")
+}
+
 func RecordRelation(dependent *File, target *File, event Event, m *Metadata) error {
 	if err := dependent.PutPrerequisite(event, target.PathHash, target.AsPrerequisite(dependent.RootDir, m)); err != nil {
 		return err
@@ -82,5 +90,12 @@ func RecordRelation(dependent *File, target *File, event Event, m *Metadata) err
 		return err
 	}
 
+	// The dependent's build keeps the prerequisites its do script declares and removes the rest.
+	if err := declare(dependent.makeKey(REQUIRES, event, target.PathHash)); err != nil {
+		return err
+	}
+
+	dependent.emit(BuildEvent{Kind: EVENT_RELATION, Prerequisite: dependent.Rel(target.Fullpath()), Relation: event})
+
 	return nil
 }
@@ -4,7 +4,280 @@
 
 package redux
 
-// Configuration container
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gyepisam/fileutils"
+)
+
+// CONFIG_FILE names the configuration file in the redo directory.
+const CONFIG_FILE = "config"
+
+// Config holds the project configuration, which is read from the CONFIG_FILE
+// in the project's redo directory. Environment variables override the file.
 type Config struct {
-	DBType string
+	// DBType is the database type: file or log.
+	// If unset, the type is determined by the files in the redo directory.
+	DBType string `json:"db"`
+
+	// Hash names the algorithm used to compute content hashes: sha1, sha256 or sha512.
+	// Changing it causes every target to be rebuilt once.
+	Hash string `json:"hash"`
+
+	// Shell runs do scripts. Overridden by REDO_SHELL.
+	Shell string `json:"shell"`
+
+	// ShellArgs are extra arguments for the shell. Overridden by REDO_SHELL_ARGS.
+	ShellArgs string `json:"shell_args"`
+
+	// Jobs is the number of targets to build in parallel. Overridden by REDO_JOBS.
+	Jobs int `json:"jobs"`
+
+	// TmpDir holds temporary output files. A relative path is relative to the redo directory.
+	// Overridden by REDO_TMP_DIR.
+	TmpDir string `json:"tmp_dir"`
+
+	// Verbosity is the level of verbosity. Overridden by REDO_VERBOSE.
+	Verbosity int `json:"verbosity"`
+
+	// Timeout limits the time each do script may run, as a duration such as "90s" or "10m".
+	// An empty value means no limit. Overridden by REDO_TIMEOUT.
+	Timeout string `json:"timeout"`
+
+	// Timeouts overrides Timeout for the targets whose paths, relative to the root directory,
+	// match its keys, which may contain shell wildcards.
+	Timeouts map[string]string `json:"timeouts"`
+
+	// Env lists the environment variables passed to do scripts. Names may contain
+	// shell wildcards. Redo's own variables are always passed.
+	// If the list is empty, the entire environment is passed.
+	Env []string `json:"env"`
+}
+
+// DefaultConfig returns the configuration used in the absence of a configuration file.
+func DefaultConfig() Config {
+	return Config{
+		Hash:   DEFAULT_HASH,
+		Shell:  "/bin/sh",
+		Jobs:   1,
+		TmpDir: "tmp",
+	}
+}
+
+var configs = struct {
+	sync.Mutex
+	m map[string]Config
+}{m: make(map[string]Config)}
+
+// LoadConfig returns the configuration for the project in rootdir.
+// The configuration file is read once per process.
+func LoadConfig(rootdir string) (Config, error) {
+	configs.Lock()
+	defer configs.Unlock()
+
+	if c, ok := configs.m[rootdir]; ok {
+		return c, nil
+	}
+
+	c, err := readConfig(rootdir)
+	if err != nil {
+		return c, err
+	}
+
+	c.applyEnv()
+
+	if c.DBType == "" {
+		c.DBType, err = DBType(rootdir)
+		if err != nil {
+			return c, err
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("%s: %s", configPath(rootdir), err)
+	}
+
+	// Opening the configured database would ignore, rather than convert, one of another type.
+	if current, err := storedDBType(rootdir); err != nil {
+		return c, err
+	} else if current != "" && current != c.DBType {
+		return c, fmt.Errorf("%s: database type is %s, but the project has a %s database. Run `redux init -db %s` to convert it",
+			configPath(rootdir), c.DBType, current, c.DBType)
+	}
+
+	configs.m[rootdir] = c
+
+	return c, nil
+}
+
+func configPath(rootdir string) string {
+	return filepath.Join(rootdir, REDO_DIR, CONFIG_FILE)
+}
+
+// readConfig returns the default configuration, updated with the values in the configuration file, if any.
+func readConfig(rootdir string) (Config, error) {
+	c := DefaultConfig()
+
+	b, err := ioutil.ReadFile(configPath(rootdir))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("cannot parse %s: %s", configPath(rootdir), err)
+	}
+
+	return c, nil
+}
+
+// WriteConfig writes the configuration file for the project in rootdir.
+func WriteConfig(rootdir string, c Config) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	configs.Lock()
+	defer configs.Unlock()
+
+	delete(configs.m, rootdir)
+
+	return fileutils.AtomicWrite(configPath(rootdir), func(tmpFile *os.File) error {
+		_, err := tmpFile.Write(append(b, '\n'))
+		return err
+	})
+}
+
+// applyEnv overrides configuration values with those of the corresponding environment variables.
+func (c *Config) applyEnv() {
+	if s := os.Getenv("REDO_SHELL"); s != "" {
+		c.Shell = s
+	}
+
+	if s := os.Getenv("REDO_SHELL_ARGS"); s != "" {
+		c.ShellArgs = s
+	}
+
+	c.Jobs = envInt("REDO_JOBS", c.Jobs)
+
+	if s := os.Getenv("REDO_TMP_DIR"); s != "" {
+		if path, err := filepath.Abs(s); err == nil {
+			s = path
+		}
+		c.TmpDir = s
+	}
+
+	if s := os.Getenv("REDO_VERBOSE"); s != "" {
+		c.Verbosity = len(s)
+	}
+
+	if s := os.Getenv("REDO_TIMEOUT"); s != "" {
+		c.Timeout = s
+	}
+}
+
+func (c Config) validate() error {
+	switch c.DBType {
+	case "", FILE_DB, LOG_DB:
+	default:
+		return fmt.Errorf("unknown database type: %s. Expected %s or %s", c.DBType, FILE_DB, LOG_DB)
+	}
+
+	if _, ok := hashAlgorithms[c.Hash]; !ok {
+		return fmt.Errorf("unknown hash algorithm: %s. Expected one of %s", c.Hash, strings.Join(HashAlgorithms(), ", "))
+	}
+
+	if c.Shell == "" {
+		return fmt.Errorf("shell cannot be empty")
+	}
+
+	if c.Jobs < 1 {
+		return fmt.Errorf("jobs must be at least 1")
+	}
+
+	if err := validTimeout(c.Timeout); err != nil {
+		return err
+	}
+
+	for pattern, timeout := range c.Timeouts {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid timeout pattern %q: %s", pattern, err)
+		}
+		if err := validTimeout(timeout); err != nil {
+			return fmt.Errorf("%s: %s", pattern, err)
+		}
+	}
+
+	for _, pattern := range c.Env {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid env pattern %q: %s", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func validTimeout(s string) error {
+	if s == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(s); err != nil {
+		return fmt.Errorf("invalid timeout %q: %s", s, err)
+	} else if d < 0 {
+		return fmt.Errorf("invalid timeout %q: must not be negative", s)
+	}
+	return nil
+}
+
+// timeout returns the time limit for the do script of the target with the given path, relative to the
+// root directory, or zero if there is none. When several patterns match, the longest one applies.
+func (c Config) timeout(path string) time.Duration {
+	s := c.Timeout
+
+	longest := -1
+	for pattern, timeout := range c.Timeouts {
+		if ok, _ := filepath.Match(pattern, path); ok && len(pattern) > longest {
+			s, longest = timeout, len(pattern)
+		}
+	}
+
+	// The configuration has been validated.
+	d, _ := time.ParseDuration(s)
+	return d
+}
+
+// passEnv returns true if the named environment variable should be passed to do scripts.
+func (c Config) passEnv(name string) bool {
+	if len(c.Env) == 0 || strings.HasPrefix(name, "REDO_") || name == "MAKEFLAGS" {
+		return true
+	}
+
+	for _, pattern := range c.Env {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tempDir returns the directory for temporary files of the project in rootdir.
+func (c Config) tempDir(rootdir string) string {
+	if filepath.IsAbs(c.TmpDir) {
+		return c.TmpDir
+	}
+	return filepath.Join(rootdir, REDO_DIR, c.TmpDir)
 }
@@ -4,6 +4,12 @@
 
 package redux
 
+import (
+	"path/filepath"
+
+	"github.com/gyepisam/fileutils"
+)
+
 type Record struct {
 	Key   string
 	Value []byte
@@ -33,11 +39,72 @@ type DB interface {
 	// GetRecords returns a list of records (keys and data) matchign the specified key prefix.
 	GetRecords(prefix string) ([]Record, error)
 
+	// GetAllRecords returns a list of all the records in the database.
+	GetAllRecords() ([]Record, error)
+
+	// Write makes all the changes in the batch. Should the process die while writing,
+	// either all of the changes are made or, the next time the database is opened, none are.
+	Write(batch *Batch) error
+
 	Close() error
 }
 
+// Database types
+const (
+	FILE_DB = "file"
+	LOG_DB  = "log"
+	NULL_DB = "null"
+)
+
+// DBType returns the type of the database found in the project root directory:
+// a LogDb if the redo directory contains a LogDb file and a FileDb otherwise.
+func DBType(rootdir string) (string, error) {
+	dbType, err := storedDBType(rootdir)
+	if dbType == "" && err == nil {
+		dbType = FILE_DB
+	}
+	return dbType, err
+}
+
+// storedDBType returns the type of the database found in the project root directory,
+// or an empty string if there is none.
+func storedDBType(rootdir string) (string, error) {
+	redodir := filepath.Join(rootdir, REDO_DIR)
+
+	if exists, err := fileutils.FileExists(filepath.Join(redodir, LOG_DB_FILE)); err != nil || exists {
+		return LOG_DB, err
+	}
+
+	if exists, err := fileutils.DirExists(filepath.Join(redodir, DATA_DIR)); err != nil || exists {
+		return FILE_DB, err
+	}
+
+	return "", nil
+}
+
+// OpenDB opens the database in the project root directory.
+func OpenDB(rootdir string) (DB, error) {
+	c, err := LoadConfig(rootdir)
+	if err != nil {
+		return nil, err
+	}
+	return openDB(rootdir, c.DBType)
+}
+
+func openDB(rootdir string, dbType string) (DB, error) {
+	switch dbType {
+	case LOG_DB:
+		if err := logDbInit(rootdir); err != nil {
+			return nil, err
+		}
+		return LogDbOpen(rootdir)
+	default:
+		return FileDbOpen(rootdir)
+	}
+}
+
 func WithDB(arg string, f func(DB) error) error {
-	db, err := FileDbOpen(arg)
+	db, err := OpenDB(arg)
 	if err != nil {
 		return err
 	}
@@ -68,6 +135,18 @@ func (f *File) DeleteRecords() error {
 		return err
 	}
 
+	if err := f.DeleteStamp(); err != nil {
+		return err
+	}
+
+	if err := f.DeleteAlways(); err != nil {
+		return err
+	}
+
+	if err := f.DeleteStats(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
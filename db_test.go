@@ -74,12 +74,23 @@ func TestDBNullKey(t *testing.T) {
 }
 
 func TestDBAction(t *testing.T) {
-	root, fn, err := initRoot()
-	if err != nil {
-		t.Fatal(err)
+	for _, dbType := range []string{FILE_DB, LOG_DB} {
+		root, fn, err := initRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := InitDirDB(root, dbType); err != nil {
+			fn()
+			t.Fatal(err)
+		}
+
+		testDBAction(t, root)
+		fn()
 	}
-	defer fn()
+}
 
+func testDBAction(t *testing.T, root string) {
 	type KeyValue struct {
 		key   string
 		value []byte
@@ -140,7 +151,7 @@ func TestDBAction(t *testing.T) {
 
 	//GetKeys
 	var keys []string
-	err = WithDB(root, func(db DB) error {
+	err := WithDB(root, func(db DB) error {
 		var err error
 		keys, err = db.GetKeys(prefix)
 		return err
@@ -181,3 +192,230 @@ func TestDBAction(t *testing.T) {
 	}
 
 }
+
+func TestDBBatch(t *testing.T) {
+	for _, dbType := range []string{FILE_DB, LOG_DB} {
+		root, fn, err := initRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := InitDirDB(root, dbType); err != nil {
+			fn()
+			t.Fatal(err)
+		}
+
+		err = WithDB(root, func(db DB) error {
+			return testDBBatch(t, db)
+		})
+		fn()
+
+		if err != nil {
+			t.Fatalf("%s: %s", dbType, err)
+		}
+	}
+}
+
+func testDBBatch(t *testing.T, db DB) error {
+	if err := db.Put("a", []byte("1")); err != nil {
+		return err
+	}
+
+	if err := db.Put("b", []byte("1")); err != nil {
+		return err
+	}
+
+	batch := new(Batch)
+	batch.Delete("a")
+	batch.Put("b", []byte("2"))
+	batch.Put("c", []byte("1"))
+	batch.Put("c", []byte("3"))
+	batch.Delete("missing")
+
+	if n := batch.Len(); n != 4 {
+		t.Errorf("want batch of 4 changes, got %d", n)
+	}
+
+	if err := db.Write(batch); err != nil {
+		return err
+	}
+
+	records, err := db.GetAllRecords()
+	if err != nil {
+		return err
+	}
+
+	want := []Record{{"b", []byte("2")}, {"c", []byte("3")}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("want records %q, got %q", want, records)
+	}
+
+	bad := new(Batch)
+	bad.Put("d", []byte("4"))
+	bad.Put("", []byte("5"))
+
+	if err := db.Write(bad); err != NullKeyErr {
+		t.Errorf("want %s, got %v", NullKeyErr, err)
+	} else if _, found, err := db.Get("d"); err != nil {
+		return err
+	} else if found {
+		t.Errorf("want no changes from a batch with an empty key")
+	}
+
+	return nil
+}
+
+// Reads within a transaction see its pending changes, which are only written when committed.
+func TestDBTransaction(t *testing.T) {
+	root, fn, err := initRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fn()
+
+	err = WithDB(root, func(db DB) error {
+		for _, key := range []string{"p/a", "p/b", "q/a"} {
+			if err := db.Put(key, []byte("1")); err != nil {
+				return err
+			}
+		}
+
+		tx := &txDB{DB: db}
+
+		if err := tx.Delete("p/a"); err != nil {
+			return err
+		} else if err := tx.Put("p/b", []byte("2")); err != nil {
+			return err
+		} else if err := tx.Put("p/c", []byte("3")); err != nil {
+			return err
+		}
+
+		keys, err := tx.GetKeys("p/")
+		if err != nil {
+			return err
+		} else if want := []string{"p/b", "p/c"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("want pending keys %q, got %q", want, keys)
+		}
+
+		if _, found, err := tx.Get("p/a"); err != nil {
+			return err
+		} else if found {
+			t.Errorf("want p/a to be deleted in the transaction")
+		}
+
+		if _, found, err := db.Get("p/c"); err != nil {
+			return err
+		} else if found {
+			t.Errorf("want p/c to be pending until commit")
+		}
+
+		if err := db.Write(&tx.batch); err != nil {
+			return err
+		}
+
+		keys, err = db.GetKeys("p/")
+		if err != nil {
+			return err
+		} else if want := []string{"p/b", "p/c"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("want committed keys %q, got %q", want, keys)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A journal left by a writer that died before applying it is replayed when the database is opened
+// or, if a transaction wrote it, when the lock of the file that owns it is acquired.
+func TestFileDbJournalReplay(t *testing.T) {
+	root, fn, err := initRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fn()
+
+	dbi, err := FileDbOpen(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := dbi.(*FileDb)
+
+	if err := db.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := new(Batch)
+	batch.Delete("a")
+	batch.Put("b", []byte("2"))
+
+	journal, path, err := db.writeJournal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The writer is still alive while it holds the journal.
+	if _, err := FileDbOpen(root); err != nil {
+		t.Fatal(err)
+	} else if got := getString(t, db, "b"); got != "<missing>" {
+		t.Errorf("want locked journal to be left alone, got b=%s", got)
+	}
+
+	journal.Close()
+
+	if _, err := FileDbOpen(root); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, db, "a"); got != "<missing>" {
+		t.Errorf("want a to be deleted, got %s", got)
+	}
+
+	if got := getString(t, db, "b"); got != "2" {
+		t.Errorf("want 2, got %s", got)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want journal %s to be removed, got %v", path, err)
+	}
+
+	// The journal of a transaction waits for the lock of the file that owns it,
+	// since a later build of the file may have written the same records.
+	f, err := NewFile(root, "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch = new(Batch)
+	batch.Put("c", []byte("3"))
+	batch.Put("d", []byte("4"))
+	batch.owner = f.PathHash
+
+	journal, path, err = db.writeJournal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	if _, err := FileDbOpen(root); err != nil {
+		t.Fatal(err)
+	} else if got := getString(t, db, "c"); got != "<missing>" {
+		t.Errorf("want the journal of a transaction to wait for the lock, got c=%s", got)
+	}
+
+	lock, err := f.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	if got := getString(t, db, "d"); got != "4" {
+		t.Errorf("want 4, got %s", got)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want journal %s to be removed, got %v", path, err)
+	}
+}
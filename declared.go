@@ -0,0 +1,124 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DECLARED_ENV names the environment variable that holds the path of the file in which
+// the redo commands of a do script list the prerequisites they record for its target.
+const DECLARED_ENV = "REDO_DECLARED"
+
+// declaredPath returns the path of the file that lists the prerequisites declared by the target's do script.
+// The target is locked while it is built, so the file is not shared.
+func (f *File) declaredPath() string {
+	return filepath.Join(f.tempDir(), string(f.PathHash)+"-redo-declared")
+}
+
+// beginDeclarations creates an empty declaration file for the target's do script.
+func (f *File) beginDeclarations() error {
+	file, err := os.Create(f.declaredPath())
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// endDeclarations removes the declaration file and returns the keys of the prerequisite records it lists.
+func (f *File) endDeclarations() (map[string]bool, error) {
+	path := f.declaredPath()
+	defer os.Remove(path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prefix := f.makeKey(REQUIRES) + KEY_SEPARATOR
+	declared := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var key string
+		if err := json.Unmarshal(scanner.Bytes(), &key); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(key, prefix) {
+			declared[key] = true
+		}
+	}
+
+	return declared, scanner.Err()
+}
+
+// declare adds the key of a prerequisite record to the declaration file of the running do script, if any.
+func declare(key string) error {
+	path := os.Getenv(DECLARED_ENV)
+	if path == "" {
+		return nil
+	}
+
+	return appendLine(path, key)
+}
+
+// A staleRelation is a prerequisite record that has been removed from its dependent.
+type staleRelation struct {
+	event Event
+	*Prerequisite
+}
+
+// deleteUndeclaredPrerequisites removes the records of the prerequisites that the do script no longer declares
+// and returns them. Records that are still declared are left alone, since the redo commands of the do script
+// only rewrite those that have changed.
+func (f *File) deleteUndeclaredPrerequisites(declared map[string]bool) ([]staleRelation, error) {
+	var stale []staleRelation
+
+	for _, event := range []Event{IFCHANGE, IFCREATE} {
+		records, err := f.eventRecords(event)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range records {
+			if declared[rec.key] {
+				continue
+			}
+
+			if err := f.Delete(rec.key); err != nil {
+				return nil, err
+			}
+
+			stale = append(stale, staleRelation{event, rec.Prerequisite})
+		}
+	}
+
+	return stale, nil
+}
+
+// deleteStaleDependencies removes the other side of the stale relations: the prerequisites' records of f as a dependent.
+// It should be called once the prerequisite records are deleted, so that a failure leaves,
+// at worst, a dependent that is notified needlessly.
+func (f *File) deleteStaleDependencies(stale []staleRelation) error {
+	for _, rel := range stale {
+		prereq, err := rel.File(f.RootDir)
+		if err != nil {
+			return err
+		}
+
+		if err := prereq.DeleteDependency(rel.event, f.PathHash); err != nil {
+			return err
+		}
+
+		f.Debug("@Undeclared %s -> %s\n", rel.event, prereq.Path)
+	}
+
+	return nil
+}
@@ -4,6 +4,10 @@
 
 package redux
 
+import (
+	"fmt"
+)
+
 // Dependent is the inverse of Prerequisite
 type Dependent struct {
 	Path string
@@ -27,16 +31,16 @@ func (p Prerequisite) File(dir string) (*File, error) {
 
 func (f *File) DependentFiles(prefix string) ([]*File, error) {
 
-	data, err := f.db.GetValues(prefix)
+	records, err := f.db.GetRecords(prefix)
 	if err != nil {
 		return nil, err
 	}
 
-	files := make([]*File, len(data))
+	files := make([]*File, len(records))
 
-	for i, b := range data {
-		if dep, err := decodeDependent(b); err != nil {
-			return nil, err
+	for i, rec := range records {
+		if dep, err := decodeDependent(rec.Value); err != nil {
+			return nil, fmt.Errorf("cannot decode dependent record %s: %s. Try redux fsck", rec.Key, err)
 		} else if item, err := dep.File(f.RootDir); err != nil {
 			return nil, err
 		} else {
@@ -73,8 +77,10 @@ func (f *File) DeleteDependency(event Event, hash Hash) error {
 	return f.Delete(f.makeKey(SATISFIES, event, hash))
 }
 
+// PutDependency stores the dependent using a key based on the event and hash.
+// An unchanged record is not rewritten.
 func (f *File) PutDependency(event Event, hash Hash, dep Dependent) error {
-	return f.Put(f.makeKey(SATISFIES, event, hash), dep)
+	return f.putIfChanged(f.makeKey(SATISFIES, event, hash), dep)
 }
 
 // NotifyDependents flags dependents as out of date because target has been created, modified,  or deleted.
@@ -90,6 +96,7 @@ func (f *File) NotifyDependents(event Event) (err error) {
 			return err
 		}
 		f.Debug("@Notify %s -> %s\n", event, dependent.Path)
+		f.emit(BuildEvent{Kind: EVENT_NOTIFY, Dependent: f.Rel(dependent.Fullpath()), Relation: event})
 	}
 
 	return nil
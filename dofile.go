@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -17,43 +18,53 @@ If a file is found the structure's Name and Arg2 fields are also set appropriate
 */
 func (f *File) findDoFile() (*DoInfo, error) {
 
-	relPath := &RelPath{}
 	var missing []string
 
-	dir := f.Dir
-	candidates := f.DoInfoCandidates()
+	for _, do := range f.DoFileSearchPath() {
+		path := do.Path()
+		exists, err := fileutils.FileExists(path)
+		f.Debug("%s %t %v\n", path, exists, err)
+		if err != nil {
+			return nil, err
+		} else if exists {
+			do.Missing = missing
+			return do, nil
+		}
 
-TOP:
-	for {
+		missing = append(missing, path)
+	}
 
-		for _, do := range candidates {
-			path := filepath.Join(dir, do.Name)
-			exists, err := fileutils.FileExists(path)
-			f.Debug("%s %t %v\n", path, exists, err)
-			if err != nil {
-				return nil, err
-			} else if exists {
-				do.Dir = dir
-				do.RelDir = relPath.Join()
-				do.Missing = missing
-				return do, nil
-			}
+	return &DoInfo{Missing: missing}, nil
+}
 
-			missing = append(missing, path)
+// DoFileSearchPath returns the possible do files for the target in search order.
+// The candidates in the target's directory are followed by those in each parent directory
+// up to the root directory. Each entry's Dir, RelDir and Arg2 fields are set as they would be
+// if the do file were chosen.
+func (f *File) DoFileSearchPath() []*DoInfo {
+
+	relPath := &RelPath{}
+	var out []*DoInfo
+
+	dir := f.Dir
+
+	for {
+		for _, do := range f.DoInfoCandidates() {
+			do.Dir = dir
+			do.RelDir = relPath.Join()
+			out = append(out, do)
 		}
 
 		if dir == f.RootDir {
-			break TOP
+			break
 		}
 		relPath.Add(filepath.Base(dir))
 		dir = filepath.Dir(dir)
 	}
 
-	return &DoInfo{Missing: missing}, nil
+	return out
 }
 
-const shell = "/bin/sh"
-
 // RunDoFile executes the do file script, records the metadata for the resulting output, then
 // saves the resulting output to the target file, if applicable.
 // The execution is equivalent to:
@@ -61,6 +72,12 @@ const shell = "/bin/sh"
 // A well behaved .do file writes to stdout (out0) or to the $3 file (outfn), but not both.
 func (target *File) RunDoFile(doInfo *DoInfo) (err error) {
 
+	// An interrupt waits for the temporary outputs to be removed.
+	if err = beginBuild(); err != nil {
+		return
+	}
+	defer endBuild()
+
 	// out0 is an open file connected to subprocess stdout
 	// However, a task subprocess, meaning it is run for side effects,
 	// emits output to stdout.
@@ -91,7 +108,25 @@ func (target *File) RunDoFile(doInfo *DoInfo) (err error) {
 		return
 	}
 
-	if err = target.runCmd(out0.File, outfn.Name(), doInfo); err != nil {
+	start := time.Now()
+	status := -1
+	doFile := target.Rel(doInfo.Path())
+
+	target.emit(BuildEvent{Kind: EVENT_START, DoFile: doFile})
+	defer func() {
+		duration := time.Since(start)
+
+		target.emit(BuildEvent{Kind: EVENT_FINISH, DoFile: doFile, Duration: duration.Seconds(),
+			Status: &status, Error: errorString(err)})
+
+		build := Build{Start: start, Duration: duration, Status: status, Failed: err != nil, DoFile: doFile}
+		// The statistics are incidental to the build, whose result stands.
+		if err2 := target.PutBuild(build); err2 != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot record build statistics for %s: %s\n", target.Target, err2)
+		}
+	}()
+
+	if status, err = target.runCmd(out0.File, outfn.Name(), doInfo); err != nil {
 		return
 	}
 
@@ -161,36 +196,48 @@ func (target *File) RunDoFile(doInfo *DoInfo) (err error) {
 	return
 }
 
-func (target *File) runCmd(out0 *os.File, outfn string, doInfo *DoInfo) error {
+// runCmd runs the do script and returns its exit status.
+func (target *File) runCmd(out0 *os.File, outfn string, doInfo *DoInfo) (int, error) {
 
 	args := []string{"-e"}
 
-	if ShellArgs != "" {
-		if ShellArgs[0] != '-' {
-			ShellArgs = "-" + ShellArgs
+	if shellArgs := target.Config.ShellArgs; shellArgs != "" {
+		if shellArgs[0] != '-' {
+			shellArgs = "-" + shellArgs
 		}
-		args = append(args, ShellArgs)
+		args = append(args, shellArgs)
 	}
 
-	pending := os.Getenv("REDO_PENDING")
-	pendingID := ";" + string(target.FullPathHash)
-	target.Debug("Current: [%s]. Pending: [%s].\n", pendingID, pending)
-
-	if strings.Contains(pending, pendingID) {
-		return fmt.Errorf("Loop detected on pending target: %s", target.Target)
+	if err := target.checkPending(); err != nil {
+		return -1, err
 	}
 
-	pending += pendingID
+	pending := os.Getenv("REDO_PENDING") + target.pendingID()
 
 	relTarget := doInfo.RelPath(target.Name)
 	args = append(args, doInfo.Name, relTarget, doInfo.RelPath(doInfo.Arg2), outfn)
 
 	target.Debug("@sh %s $3\n", strings.Join(args[0:len(args)-1], " "))
 
+	shell := target.Config.Shell
 	cmd := exec.Command(shell, args...)
 	cmd.Dir = doInfo.Dir
 	cmd.Stdout = out0
-	cmd.Stderr = os.Stderr
+
+	// Standard error is shown as it is written and saved in the build log.
+	buildLog, err := target.newBuildLog()
+	if err != nil {
+		return -1, err
+	}
+
+	stderr, err := newLogPipe(buildLog)
+	if err != nil {
+		buildLog.Close()
+		return -1, err
+	}
+	defer stderr.Close()
+
+	cmd.Stderr = stderr.File
 
 	depth := 0
 	if i64, err := strconv.ParseInt(os.Getenv("REDO_DEPTH"), 10, 32); err == nil {
@@ -200,13 +247,21 @@ func (target *File) runCmd(out0 *os.File, outfn string, doInfo *DoInfo) error {
 	parent := os.Getenv("REDO_PARENT")
 
 	// Add environment variables, replacing existing entries if necessary.
-	cmdEnv := os.Environ()
+	var cmdEnv []string
+	for _, entry := range os.Environ() {
+		if name := strings.SplitN(entry, "=", 2)[0]; target.Config.passEnv(name) {
+			cmdEnv = append(cmdEnv, entry)
+		}
+	}
 	env := map[string]string{
 		"REDO_PARENT":  relTarget,
 		"REDO_DEPTH":   strconv.Itoa(depth + 1),
 		"REDO_PENDING": pending,
+		DECLARED_ENV:   target.declaredPath(),
 	}
 
+	getJobServer().setupCmd(cmd, env)
+
 	// Update environment values if they exist and append when they dont.
 TOP:
 	for key, value := range env {
@@ -230,14 +285,24 @@ TOP:
 		target.Log("%s%s (%s)\n", prefix, target.Rel(target.Fullpath()), target.Rel(doInfo.Path()))
 	}
 
-	err := cmd.Run()
+	start := time.Now()
+	err = runScript(cmd, target.Config.timeout(target.Path))
+	status := exitStatus(cmd, err)
+
+	span := Span{Target: target.Fullpath(), DoFile: doInfo.Path(), Parent: parent, Depth: depth,
+		Command: filepath.Base(os.Args[0]), PID: os.Getpid(), Start: start, Duration: time.Since(start), Status: status}
+	if cmd.Process != nil {
+		span.ShellPID = cmd.Process.Pid
+	}
+	recordSpan(span)
+
 	if err == nil {
-		return nil
+		return status, nil
 	}
 
 	if Verbose() {
-		return target.Errorf("%s %s: %s", shell, strings.Join(args, " "), err)
+		return status, target.Errorf("%s %s: %s", shell, strings.Join(args, " "), err)
 	}
 
-	return target.Errorf("%s", err)
+	return status, target.Errorf("%s", err)
 }
@@ -5,6 +5,7 @@
 package redux
 
 import (
+	"bytes"
 	"encoding/json"
 	"path/filepath"
 )
@@ -54,3 +55,22 @@ func (f *File) Put(key string, obj interface{}) (err error) {
 	}
 	return f.db.Put(key, b)
 }
+
+// putIfChanged is like Put, but does not rewrite a record whose value is unchanged.
+func (f *File) putIfChanged(key string, obj interface{}) (err error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	old, found, err := f.db.Get(key)
+	if err != nil {
+		return err
+	} else if found && bytes.Equal(old, b) {
+		f.Debug("@Put %s unchanged\n", key)
+		return nil
+	}
+
+	defer f.Debug("@Put %s -> %s\n", key, err)
+	return f.db.Put(key, b)
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// EVENTS_ENV names the environment variable that holds the path of the file to which
+// the processes of a build write their events.
+const EVENTS_ENV = "REDO_EVENTS"
+
+// Kinds of build events.
+const (
+	EVENT_START    = "start"    // a do script is started.
+	EVENT_FINISH   = "finish"   // a do script has finished, successfully or not.
+	EVENT_DO_FILE  = "do_file"  // a do file was chosen for a target.
+	EVENT_CURRENT  = "current"  // redo-ifchange found a target up to date.
+	EVENT_RELATION = "relation" // a dependency was recorded.
+	EVENT_NOTIFY   = "notify"   // a dependent was flagged for rebuild.
+)
+
+// A BuildEvent describes build activity. Events are written to the file named by EVENTS_ENV
+// as JSON objects, one per line. Paths are relative to the root directory.
+type BuildEvent struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"event"`
+	RunID string    `json:"run_id"`
+	PID   int       `json:"pid"`
+	Root  string    `json:"root"`
+
+	Target       string  `json:"target"`
+	DoFile       string  `json:"do_file,omitempty"`
+	Prerequisite string  `json:"prerequisite,omitempty"` // for relation events.
+	Dependent    string  `json:"dependent,omitempty"`    // for notify events.
+	Relation     Event   `json:"relation,omitempty"`     // for relation and notify events.
+	Duration     float64 `json:"duration,omitempty"`     // in seconds, for finish events.
+	Status       *int    `json:"status,omitempty"`       // do script exit status, for finish events.
+	Error        string  `json:"error,omitempty"`
+}
+
+// Events names the event file. Events are not written if it is empty.
+var Events = os.Getenv(EVENTS_ENV)
+
+// emit completes the event for the file and appends it to the event file, if any.
+// A failure to write the event is reported, but does not affect the build.
+func (f *File) emit(event BuildEvent) {
+	if Events == "" {
+		return
+	}
+
+	event.Time = time.Now()
+	event.RunID = RunID
+	event.PID = os.Getpid()
+	event.Root = f.RootDir
+	event.Target = f.Path
+
+	if err := appendLine(Events, event); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot write event to %s: %s\n", Events, err)
+	}
+}
+
+// exitStatus returns the exit status of a command that returned err,
+// or -1 if the command did not run to completion.
+func exitStatus(cmd *exec.Cmd, err error) int {
+	if err == nil {
+		return 0
+	}
+	if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
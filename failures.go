@@ -0,0 +1,70 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// FAILURES_ENV names the environment variable that holds the path of the file in which
+// the processes of a keep going run record their failed targets.
+const FAILURES_ENV = "REDO_FAILURES"
+
+// A Failure records a target that failed to build.
+type Failure struct {
+	Target string // full path to the target.
+	DoFile string // full path to the do script, if any.
+	Error  string
+}
+
+// NewFailureLog creates an empty failure log and sets FAILURES_ENV so that the current process
+// and its children record their failures in it. It returns the path to the log.
+func NewFailureLog() (string, error) {
+	return newTempLog("redo-failures-", FAILURES_ENV)
+}
+
+// RecordFailure adds the target and the error that caused it to fail to the failure log, if any.
+func (f *File) RecordFailure(cause error) error {
+	path := os.Getenv(FAILURES_ENV)
+	if path == "" {
+		return nil
+	}
+
+	return appendLine(path, Failure{
+		Target: f.Fullpath(),
+		DoFile: f.DoFile,
+		Error:  strings.TrimPrefix(cause.Error(), f.Target+": "),
+	})
+}
+
+// ReadFailures returns the failures in the log at path, in the order they were recorded.
+// A target that failed more than once is only included once.
+func ReadFailures(path string) ([]Failure, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out []Failure
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var failure Failure
+		if err := json.Unmarshal(scanner.Bytes(), &failure); err != nil {
+			return nil, err
+		}
+		if !seen[failure.Target] {
+			seen[failure.Target] = true
+			out = append(out, failure)
+		}
+	}
+
+	return out, scanner.Err()
+}
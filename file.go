@@ -86,31 +86,17 @@ func NewFile(dir, path string) (f *File, err error) {
 
 	f.Target = path
 
-	rootDir, filename := splitpath(targetPath)
-	relPath := &RelPath{}
-	relPath.Add(filename)
-
-	hasRoot := false
-
-	for {
-		exists, err := fileutils.DirExists(filepath.Join(rootDir, REDO_DIR))
-		if err != nil {
-			return nil, err
-		}
-		if exists {
-			hasRoot = true
-			break
-		}
-		if rootDir == "/" || rootDir == "." {
-			break
-		}
-		rootDir, filename = splitpath(rootDir)
-		relPath.Add(filename)
+	rootDir, hasRoot, err := FindRootDir(filepath.Dir(targetPath))
+	if err != nil {
+		return nil, err
 	}
 
 	f.RootDir = rootDir
 
-	f.Path = relPath.Join()
+	f.Path, err = filepath.Rel(rootDir, targetPath)
+	if err != nil {
+		return nil, err
+	}
 
 	f.PathHash = MakeHash(f.Path)
 	f.FullPathHash = MakeHash(filepath.Join(f.RootDir, f.Path))
@@ -121,10 +107,12 @@ func NewFile(dir, path string) (f *File, err error) {
 	f.Ext = filepath.Ext(f.Name)
 
 	if hasRoot {
-		// TODO(gsam): Read config file in rootDir to determine DB type, if any.
-		// Default to FileDB if not specified.
-		// f.Config = Config{DBType: "file"}
-		f.db, err = FileDbOpen(f.RootDir)
+		f.Config, err = LoadConfig(f.RootDir)
+		if err != nil {
+			return nil, err
+		}
+
+		f.db, err = openDB(f.RootDir, f.Config.DBType)
 		if err != nil {
 			return nil, err
 		}
@@ -135,7 +123,9 @@ func NewFile(dir, path string) (f *File, err error) {
 		}
 
 	} else {
-		f.Config = Config{DBType: "null"}
+		f.Config = DefaultConfig()
+		f.Config.applyEnv()
+		f.Config.DBType = NULL_DB
 		f.db, err = NullDbOpen("")
 		if err != nil {
 			return nil, err
@@ -146,6 +136,26 @@ func NewFile(dir, path string) (f *File, err error) {
 	return
 }
 
+// FindRootDir searches dir and its parent directories for the project root directory,
+// which contains the .redo directory. If there is none, found is false and the last directory
+// searched, which is either "/" or ".", is returned.
+func FindRootDir(dir string) (rootDir string, found bool, err error) {
+	rootDir = dir
+	for {
+		exists, err := fileutils.DirExists(filepath.Join(rootDir, REDO_DIR))
+		if err != nil {
+			return "", false, err
+		}
+		if exists {
+			return rootDir, true, nil
+		}
+		if rootDir == "/" || rootDir == "." {
+			return rootDir, false, nil
+		}
+		rootDir = filepath.Dir(rootDir)
+	}
+}
+
 // HasNullDb specifies whether the File receiver uses a NullDb.
 func (f *File) HasNullDb() bool {
 	return f.db.IsNull()
@@ -193,72 +203,98 @@ func (f *File) HasDoFile() bool {
 //   All the file's prerequisites are also current.
 
 func (f *File) IsCurrent() (bool, error) {
-	return f.isCurrent()
+	outdated, err := f.Outdated()
+	return outdated == nil && err == nil, err
 }
 
-func (f *File) isCurrent() (bool, error) {
+// Outdated returns an explanation of why the target is out of date, or nil if it is current.
+// The conditions checked are the same as for IsCurrent, in the same order, so the
+// explanation describes the first reason found.
+func (f *File) Outdated() (*Outdated, error) {
 
-	reason := func(msg string) (bool, error) {
+	reason := func(msg string) (*Outdated, error) {
 		f.Debug("@Outdated because %s\n", msg)
-		return false, nil
+		return &Outdated{Path: f.Path, Reason: msg}, nil
 	}
 
 	if f.MustRebuild() {
-		return reason("REBUILD")
+		return reason(OUTDATED_REBUILD)
+	}
+
+	if f.MustAlwaysRebuild() {
+		return reason(OUTDATED_ALWAYS)
 	}
 
 	storedMeta, found, err := f.GetMetadata()
 	if err != nil {
-		return false, err
+		return nil, err
 	} else if !found {
-		return reason("no record metadata")
+		return reason(OUTDATED_NO_RECORD)
 	}
 
 	fileMeta, err := f.NewMetadata()
 	if err != nil {
-		return false, err
+		return nil, err
 	} else if fileMeta == nil {
-		return reason("no file metadata")
+		return reason(OUTDATED_MISSING)
 	}
 
 	if !storedMeta.Equal(fileMeta) {
-		return reason("record metadata != file metadata")
+		return reason(OUTDATED_CONTENT)
 	}
 
 	// redo-ifcreate dependencies
 	created, err := f.PrerequisiteFiles(IFCREATE, AUTO_IFCREATE)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	for _, prerequisite := range created {
 		if exists, err := prerequisite.Exists(); err != nil {
-			return false, err
+			return nil, err
 		} else if exists {
-			return reason("ifcreate dependency target exists")
+			f.Debug("@Outdated because ifcreate dependency %s exists\n", prerequisite.Path)
+			return &Outdated{Path: f.Path, Reason: OUTDATED_PREREQUISITE,
+				Cause: &Outdated{Path: prerequisite.Path, Reason: OUTDATED_CREATED}}, nil
 		}
 	}
 
 	// redo-ifchange dependencies
 	changed, err := f.Prerequisites(IFCHANGE, AUTO_IFCHANGE)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	for _, prerequisite := range changed {
-		if isCurrent, err := prerequisite.IsCurrent(f.RootDir); err != nil || !isCurrent {
-			return isCurrent, err
+		if cause, err := prerequisite.Outdated(f.RootDir); err != nil {
+			return nil, err
+		} else if cause != nil {
+			f.Debug("@Outdated because prerequisite %s is out of date\n", cause.Path)
+			return &Outdated{Path: f.Path, Reason: OUTDATED_PREREQUISITE, Cause: cause}, nil
 		}
 	}
 
-	return true, nil
+	return nil, nil
 }
 
 // NewMetadata computes and returns the file metadata.
+// If the file status is unchanged since its metadata was recorded, the recorded content hash is
+// reused rather than recomputed. If the file content is unchanged, the recorded stamp, if any,
+// is included. The recorded metadata is left as it is; see refreshStat.
 func (f *File) NewMetadata() (m *Metadata, err error) {
 
-	m, err = NewMetadata(f.Fullpath(), f.Path)
-	if m == nil || err == nil {
+	stored, found, err := f.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	var known *Metadata
+	if found {
+		known = &stored
+	}
+
+	m, err = newMetadata(f.Fullpath(), f.Path, f.Config.Hash, known)
+	if m == nil || err != nil {
 		return
 	}
 
@@ -270,12 +306,34 @@ func (f *File) NewMetadata() (m *Metadata, err error) {
 		}
 	}
 
+	if found && stored.ContentHash == m.ContentHash {
+		m.Stamp = stored.Stamp
+	}
+
 	return
 }
 
-// ContentHash returns a cryptographic hash of the file contents.
+// refreshStat records the file status in m, the file's new metadata, if the file was touched,
+// but not changed, since its metadata was recorded, so that it need not be hashed again.
+// The caller must hold the file's lock, so that a concurrent build's metadata is not overwritten.
+func (f *File) refreshStat(m *Metadata) error {
+	if m == nil || !m.hasStat() {
+		return nil
+	}
+
+	stored, found, err := f.GetMetadata()
+	if err != nil || !found || stored.ContentHash != m.ContentHash || m.sameStat(&stored) {
+		return err
+	}
+
+	f.Debug("@Refresh status %s\n", f.Path)
+	stored.setStatFrom(m)
+	return f.PutMetadata(&stored)
+}
+
+// ContentHash returns a cryptographic hash of the file contents, computed with the configured algorithm.
 func (f *File) ContentHash() (Hash, error) {
-	return ContentHash(f.Fullpath())
+	return FileHash(f.Fullpath(), f.Config.Hash)
 }
 
 // Log prints out messages to stderr when the verbosity is greater than N.
@@ -319,10 +377,7 @@ func (f *File) RedoDir() string {
 }
 
 func (f *File) tempDir() string {
-	if s := os.Getenv("REDO_TMP_DIR"); len(s) > 0 {
-		return s
-	}
-	return filepath.Join(f.RedoDir(), "tmp")
+	return f.Config.tempDir(f.RootDir)
 }
 
 func (f *File) tempFile() (*os.File, error) {
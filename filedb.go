@@ -7,6 +7,7 @@ package redux
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -18,11 +19,24 @@ import (
 const (
 	// Where is data kept?
 	DATA_DIR = "data"
+
+	// Where are batches kept while they are written?
+	JOURNAL_DIR = "journal"
+
+	// Names the journals of batches that are not written by transactions.
+	unownedJournal = "batch"
 )
 
 // FileDb is a file based DB for storing Redo relationships and metadata.
+//
+// Each record is a file in the data directory. A batch of changes is first written to a
+// journal file, which its writer holds locked, and is then applied a record at a time.
+// A journal left by a writer that died is replayed when the lock of the file whose transaction
+// wrote it is next acquired, so that it never replaces records written by a later build.
+// The journals of other batches are replayed when the database is opened.
 type FileDb struct {
-	DataDir string
+	DataDir    string
+	JournalDir string
 }
 
 var NullKeyErr = errors.New("Key cannot be empty.")
@@ -44,7 +58,13 @@ func FileDbOpen(rootdir string) (DB, error) {
 		return nil, fmt.Errorf("FileDb cannot make data directory [%s]. %s", datadir, err)
 	}
 
-	return &FileDb{DataDir: datadir}, nil
+	db := &FileDb{DataDir: datadir, JournalDir: filepath.Join(redodir, JOURNAL_DIR)}
+
+	if err := db.replay(unownedJournal); err != nil {
+		return nil, err
+	}
+
+	return db, nil
 }
 
 func (db *FileDb) IsNull() bool { return false }
@@ -103,18 +123,36 @@ func (db *FileDb) GetRecords(prefix string) ([]Record, error) {
 		return nil, NullPrefixErr
 	}
 
+	return db.walk(prefix)
+}
+
+// GetAllRecords returns a list of all the records in the database.
+func (db *FileDb) GetAllRecords() ([]Record, error) {
+	return db.walk("")
+}
+
+// walk returns the records whose keys begin with prefix.
+func (db *FileDb) walk(prefix string) ([]Record, error) {
+
 	var out []Record
 	rootLen := len(db.DataDir) + 1
 
+	// Concurrent writers may remove files while the walk is in progress.
+	// Such files are ignored, as are the hidden temporary files created by atomic writes.
 	walker := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
 			return err
 		}
 		key := path[rootLen:]
 		// Go 1.0.x compatible syntax for info.Mode().IsRegular()
-		if isRegular := info.Mode()&os.ModeType == 0; isRegular && strings.HasPrefix(key, prefix) {
+		if isRegular := info.Mode()&os.ModeType == 0; isRegular && strings.HasPrefix(key, prefix) && !strings.HasPrefix(info.Name(), ".") {
 			if b, err := ioutil.ReadFile(path); err != nil {
-				return err
+				if !os.IsNotExist(err) {
+					return err
+				}
 			} else {
 				out = append(out, Record{Key: key, Value: b})
 			}
@@ -155,3 +193,214 @@ func (db *FileDb) GetValues(prefix string) ([][]byte, error) {
 	}
 	return out, nil
 }
+
+// Write records the batch in a journal, then applies it.
+// If the changes cannot all be made, the journal is kept so that they are completed later.
+func (db *FileDb) Write(batch *Batch) error {
+	for _, op := range batch.ops {
+		if len(op.key) == 0 {
+			return NullKeyErr
+		}
+	}
+
+	// A single change is atomic on its own.
+	if batch.Len() < 2 {
+		return batch.apply(db)
+	}
+
+	journal, path, err := db.writeJournal(batch)
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	if err := batch.apply(db); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// writeJournal writes the batch to a new journal file and returns it, locked, with its path.
+// The journal is written under a hidden name and renamed once it is complete,
+// so that replay never sees a partial batch.
+func (db *FileDb) writeJournal(batch *Batch) (*os.File, string, error) {
+	if err := os.Mkdir(db.JournalDir, DIR_PERM); err != nil && !os.IsExist(err) {
+		return nil, "", fmt.Errorf("FileDb cannot make journal directory [%s]. %s", db.JournalDir, err)
+	}
+
+	owner := unownedJournal
+	if batch.owner != "" {
+		owner = string(batch.owner)
+	}
+
+	file, err := ioutil.TempFile(db.JournalDir, "."+owner+"-")
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = lockFile(file)
+
+	for _, op := range batch.ops {
+		if err != nil {
+			break
+		}
+		if op.delete {
+			_, err = file.Write(encodeLogRecord(logDelete, op.key, nil))
+		} else {
+			_, err = file.Write(encodeLogRecord(logPut, op.key, op.value))
+		}
+	}
+
+	// The journal guards against the death of the process, not of the system, so it is not synced.
+	if err == nil {
+		path := filepath.Join(db.JournalDir, strings.TrimPrefix(filepath.Base(file.Name()), "."))
+		if err = os.Rename(file.Name(), path); err == nil {
+			// The file remains open, and locked, under its new name.
+			return file, path, nil
+		}
+	}
+
+	os.Remove(file.Name())
+	file.Close()
+	return nil, "", err
+}
+
+// journalOwner returns the owner in the name of a journal.
+func journalOwner(name string) string {
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// replay applies, then removes, the journals of the owner that are not locked by their writers.
+func (db *FileDb) replay(owner string) error {
+	infos, err := ioutil.ReadDir(db.JournalDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") || journalOwner(info.Name()) != owner {
+			continue
+		}
+
+		if err := db.replayJournal(filepath.Join(db.JournalDir, info.Name())); err != nil {
+			return fmt.Errorf("FileDb cannot replay journal [%s]. %s", info.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+func (db *FileDb) replayJournal(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	if locked, err := tryLockFile(file); err != nil || !locked {
+		return err
+	}
+
+	// Another process may have replayed and removed the journal before the lock was acquired.
+	if info, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if openInfo, err := file.Stat(); err != nil {
+		return err
+	} else if !os.SameFile(info, openInfo) {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	batch := new(Batch)
+	for len(data) > 0 {
+		// A journal that cannot be read, perhaps because the system crashed
+		// before it was written out, is discarded.
+		op, key, value, n, err := decodeLogRecord(data)
+		if err != nil || op == logBatch {
+			return os.Remove(path)
+		}
+
+		if op == logDelete {
+			batch.Delete(key)
+		} else {
+			batch.Put(key, value)
+		}
+		data = data[n:]
+	}
+
+	if err := batch.apply(db); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// replayJournals completes the transactions on the file's records that were interrupted by the death
+// of their writers. It is called with the file's lock held.
+func (f *File) replayJournals() error {
+	if db, ok := f.db.(*FileDb); ok {
+		return db.replay(string(f.PathHash))
+	}
+	return nil
+}
+
+// removeEmptyDirs removes the directories in the data directory that no longer hold any records.
+func (db *FileDb) removeEmptyDirs() error {
+	var dirs []string
+
+	err := filepath.Walk(db.DataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && path != db.DataDir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The walk lists parents before their children, which must be removed first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir, err := os.Open(dirs[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+
+		_, err = dir.Readdirnames(1)
+		dir.Close()
+
+		if err == io.EOF {
+			if err := os.Remove(dirs[i]); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
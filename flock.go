@@ -0,0 +1,42 @@
+// +build !windows
+
+package redux
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile attempts to acquire an exclusive lock on file without blocking.
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile acquires an exclusive lock on file, blocking until it is available.
+func lockFile(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// lockFileShared acquires a shared lock on file, blocking until it is available.
+func lockFileShared(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockFile releases a lock acquired by lockFile or lockFileShared.
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,24 @@
+// +build windows
+
+package redux
+
+import (
+	"os"
+)
+
+// File locks are not supported on windows so concurrent builds are not excluded.
+func tryLockFile(file *os.File) (bool, error) {
+	return true, nil
+}
+
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func lockFileShared(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
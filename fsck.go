@@ -0,0 +1,277 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Problem is an inconsistency in the database.
+type Problem struct {
+	Key         string // of the record that has the problem, if any.
+	Description string
+	Repair      string // describes the repair, or is empty if the problem cannot be repaired.
+}
+
+// A Check holds the problems found in a database and the changes that repair them.
+type Check struct {
+	Problems []Problem
+	repairs  Batch
+}
+
+// Repairable returns the number of problems that Repair fixes.
+func (c *Check) Repairable() int {
+	n := 0
+	for _, p := range c.Problems {
+		if p.Repair != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Repair fixes the problems that can be fixed, in a single batch.
+func (c *Check) Repair(db DB) error {
+	return db.Write(&c.repairs)
+}
+
+func (c *Check) problem(key string, repair string, format string, args ...interface{}) {
+	c.Problems = append(c.Problems, Problem{Key: key, Description: fmt.Sprintf(format, args...), Repair: repair})
+}
+
+// rebuild flags the file whose records are keyed by hash for rebuild, so that its records are rewritten.
+func (c *Check) rebuild(hash Hash) {
+	c.repairs.Put((&File{PathHash: hash}).mustRebuildKey(), []byte("null"))
+}
+
+// CheckDB checks the consistency of the database. It reports
+//
+//	records that cannot be decoded,
+//	records of unknown kinds,
+//	metadata records whose path does not hash to their key,
+//	prerequisite records without the matching dependent record in the prerequisite's records and
+//	cycles among the ifchange prerequisites.
+//
+// A record that cannot be decoded is deleted and, if its file would otherwise be considered up to date,
+// the file is flagged for rebuild. Metadata with the wrong path is deleted, which leaves its file out of date.
+// A missing dependent record is written, if the dependent's path is known, and otherwise the prerequisite
+// record is deleted and its file flagged for rebuild. Unknown records and cycles are only reported.
+func CheckDB(db DB) (*Check, error) {
+	records, err := db.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(Check)
+
+	paths := make(map[Hash]string)
+	satisfies := make(map[string]bool)
+	var requires []relationRecord
+	prereqPaths := make(map[string]string)
+
+	for _, rec := range records {
+		parts := strings.Split(rec.Key, KEY_SEPARATOR)
+		hash := Hash(parts[0])
+
+		invalid := func(err error, repair string) {
+			c.problem(rec.Key, repair, "cannot decode record: %s", err)
+			c.repairs.Delete(rec.Key)
+		}
+
+		if len(parts) == 2 {
+			switch parts[1] {
+			case "METADATA":
+				var m Metadata
+				// A file without metadata is out of date.
+				if err := json.Unmarshal(rec.Value, &m); err != nil {
+					invalid(err, "delete record")
+				} else if MakeHash(m.Path) != hash {
+					c.problem(rec.Key, "delete record", "metadata path %s does not match the key", m.Path)
+					c.repairs.Delete(rec.Key)
+				} else {
+					paths[hash] = m.Path
+				}
+
+			case "STATS":
+				var stats BuildStats
+				if err := json.Unmarshal(rec.Value, &stats); err != nil {
+					invalid(err, "delete record")
+				} else if MakeHash(stats.Path) == hash {
+					paths[hash] = stats.Path
+				}
+
+			case "REBUILD":
+				var x string
+				if err := json.Unmarshal(rec.Value, &x); err != nil {
+					c.problem(rec.Key, "rewrite record", "cannot decode record: %s", err)
+					c.rebuild(hash)
+				}
+
+			case "STAMP":
+				var stamp Hash
+				if err := json.Unmarshal(rec.Value, &stamp); err != nil {
+					invalid(err, "delete record")
+				}
+
+			case "ALWAYS":
+				var runID string
+				if err := json.Unmarshal(rec.Value, &runID); err != nil {
+					invalid(err, "delete record and flag file for rebuild")
+					c.rebuild(hash)
+				}
+
+			default:
+				c.problem(rec.Key, "", "unknown record")
+			}
+			continue
+		}
+
+		if n := len(parts); n < 4 || (parts[1] != string(REQUIRES) && parts[1] != string(SATISFIES)) {
+			c.problem(rec.Key, "", "unknown record")
+			continue
+		}
+
+		rel := relationRecord{
+			key:      rec.Key,
+			owner:    hash,
+			relation: Relation(parts[1]),
+			event:    Event(strings.Join(parts[2:len(parts)-1], KEY_SEPARATOR)),
+			other:    Hash(parts[len(parts)-1]),
+		}
+
+		if rel.relation == SATISFIES {
+			var dep Dependent
+			if err := json.Unmarshal(rec.Value, &dep); err != nil {
+				invalid(err, "delete record")
+				continue
+			}
+
+			satisfies[rec.Key] = true
+			if MakeHash(dep.Path) == rel.other {
+				paths[rel.other] = dep.Path
+			}
+			continue
+		}
+
+		prereq, err := decodePrerequisite(rec.Value)
+		if err != nil {
+			invalid(err, "delete record and flag file for rebuild")
+			c.rebuild(hash)
+			continue
+		}
+
+		// A prerequisite in another project has its records in that project's database.
+		if MakeHash(prereq.Path) == rel.other {
+			paths[rel.other] = prereq.Path
+			prereqPaths[rec.Key] = prereq.Path
+			requires = append(requires, rel)
+		}
+	}
+
+	pathOf := func(hash Hash) string {
+		if path, ok := paths[hash]; ok {
+			return path
+		}
+		return string(hash)
+	}
+
+	// System generated prerequisites, the do file and missing do files, have no dependent records.
+	for _, rel := range requires {
+		if rel.event != IFCHANGE && rel.event != IFCREATE {
+			continue
+		}
+
+		inverse := strings.Join([]string{string(rel.other), string(SATISFIES), string(rel.event), string(rel.owner)}, KEY_SEPARATOR)
+		if satisfies[inverse] {
+			continue
+		}
+
+		description := fmt.Sprintf("%s requires %s (%s), but has no matching dependent record", pathOf(rel.owner), prereqPaths[rel.key], rel.event)
+
+		if path, ok := paths[rel.owner]; ok {
+			b, err := json.Marshal(Dependent{Path: path})
+			if err != nil {
+				return nil, err
+			}
+			c.problem(rel.key, "write dependent record", "%s", description)
+			c.repairs.Put(inverse, b)
+		} else {
+			c.problem(rel.key, "delete record and flag file for rebuild", "%s", description)
+			c.repairs.Delete(rel.key)
+			c.rebuild(rel.owner)
+		}
+	}
+
+	for _, cycle := range findCycles(requires) {
+		names := make([]string, len(cycle))
+		for i, hash := range cycle {
+			names[i] = pathOf(hash)
+		}
+		c.problem("", "", "dependency cycle: %s", strings.Join(names, " -> "))
+	}
+
+	return c, nil
+}
+
+// findCycles returns the cycles among the ifchange prerequisites. Each cycle starts and ends with the same file.
+func findCycles(requires []relationRecord) [][]Hash {
+	edges := make(map[Hash][]Hash)
+	for _, rel := range requires {
+		if rel.event == IFCHANGE || rel.event == AUTO_IFCHANGE {
+			edges[rel.owner] = append(edges[rel.owner], rel.other)
+		}
+	}
+
+	var nodes []string
+	for node := range edges {
+		nodes = append(nodes, string(node))
+	}
+	sort.Strings(nodes)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[Hash]int)
+	var stack []Hash
+	var cycles [][]Hash
+
+	var visit func(node Hash)
+	visit = func(node Hash) {
+		state[node] = visiting
+		stack = append(stack, node)
+
+		for _, next := range edges[node] {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == next {
+						cycle := append([]Hash{}, stack[i:]...)
+						cycles = append(cycles, append(cycle, next))
+						break
+					}
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[node] = visited
+	}
+
+	for _, node := range nodes {
+		if state[Hash(node)] == unvisited {
+			visit(Hash(node))
+		}
+	}
+
+	return cycles
+}
@@ -0,0 +1,336 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A StaleFile is a file that no longer exists and has no do file, so its records serve no purpose.
+type StaleFile struct {
+	Path string // relative to the root directory.
+	Keys []string
+}
+
+// A DanglingRecord is a relation record that refers to a stale file or whose inverse record is missing.
+// Paths that are not known are replaced by hashes.
+type DanglingRecord struct {
+	Key      string
+	Path     string // of the file that holds the record.
+	Relation Relation
+	Event    Event
+	Other    string // path of the prerequisite or dependent.
+}
+
+// Garbage lists the records and files of a project that serve no purpose.
+type Garbage struct {
+	Files     []StaleFile
+	Dangling  []DanglingRecord
+	Rebuild   []string // paths of the files that are flagged for rebuild because their prerequisite records are removed.
+	TempFiles []string // full paths of the temporary files left by builds that did not finish.
+	BuildLogs []string // full paths of the build logs of files without records.
+
+	// BuildInProgress is true if a target was locked, in which case temporary files are not listed
+	// and the garbage cannot be collected.
+	BuildInProgress bool
+
+	rootDir     string
+	rebuildKeys []string
+}
+
+// Len returns the number of records and files in the garbage.
+func (g *Garbage) Len() int {
+	n := len(g.Dangling) + len(g.TempFiles) + len(g.BuildLogs)
+	for _, f := range g.Files {
+		n += len(f.Keys)
+	}
+	return n
+}
+
+// A relationRecord is a requires or satisfies record, decoded from its key and value.
+type relationRecord struct {
+	key      string
+	owner    Hash
+	relation Relation
+	event    Event
+	other    Hash
+}
+
+// FindGarbage returns the garbage in the project in rootDir, whose database is db.
+//
+// A file is stale if it does not exist, has no do file and is not an ifcreate prerequisite
+// of a file that is not stale. The paths of files are taken from their metadata and from the records
+// of the files that refer to them, so the records of a file that is not referred to by path are kept.
+// A relation record is dangling if it refers to a stale file or, for a satisfies record, if the dependent
+// no longer requires the file. A target whose prerequisite record is dangling is flagged for rebuild.
+func FindGarbage(rootDir string, db DB) (*Garbage, error) {
+	start := time.Now()
+
+	records, err := db.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[Hash][]string)
+	paths := make(map[Hash]string)
+	requires := make(map[string]bool)
+	var relations []relationRecord
+
+	for _, rec := range records {
+		parts := strings.Split(rec.Key, KEY_SEPARATOR)
+		hash := Hash(parts[0])
+		keys[hash] = append(keys[hash], rec.Key)
+
+		// Metadata, stats, prerequisites and dependents all record a path.
+		var value struct {
+			Path string
+		}
+
+		if len(parts) == 2 && (parts[1] == "METADATA" || parts[1] == "STATS") {
+			if err := json.Unmarshal(rec.Value, &value); err != nil {
+				return nil, fmt.Errorf("cannot decode record %s: %s", rec.Key, err)
+			}
+			if value.Path != "" {
+				paths[hash] = value.Path
+			}
+		} else if n := len(parts); n >= 4 && (parts[1] == string(REQUIRES) || parts[1] == string(SATISFIES)) {
+			if err := json.Unmarshal(rec.Value, &value); err != nil {
+				return nil, fmt.Errorf("cannot decode record %s: %s", rec.Key, err)
+			}
+
+			rel := relationRecord{
+				key:      rec.Key,
+				owner:    hash,
+				relation: Relation(parts[1]),
+				event:    Event(strings.Join(parts[2:n-1], KEY_SEPARATOR)),
+				other:    Hash(parts[n-1]),
+			}
+
+			// A path relative to another root does not hash to the key.
+			if MakeHash(value.Path) == rel.other {
+				paths[rel.other] = value.Path
+			}
+
+			if rel.relation == REQUIRES {
+				requires[rec.Key] = true
+			}
+
+			relations = append(relations, rel)
+		}
+	}
+
+	stale := make(map[Hash]bool)
+	for hash := range keys {
+		if path, ok := paths[hash]; ok {
+			if isStale, err := staleFile(rootDir, path); err != nil {
+				return nil, err
+			} else if isStale {
+				stale[hash] = true
+			}
+		}
+	}
+
+	// A file that does not exist is still of use as the ifcreate prerequisite of one that does.
+	for _, rel := range relations {
+		if rel.relation == REQUIRES && (rel.event == IFCREATE || rel.event == AUTO_IFCREATE) && !stale[rel.owner] {
+			delete(stale, rel.other)
+		}
+	}
+
+	g := &Garbage{rootDir: rootDir}
+
+	for hash := range stale {
+		g.Files = append(g.Files, StaleFile{Path: paths[hash], Keys: keys[hash]})
+	}
+	sort.Sort(staleFilesByPath(g.Files))
+
+	rebuild := make(map[Hash]bool)
+
+	pathOf := func(hash Hash) string {
+		if path, ok := paths[hash]; ok {
+			return path
+		}
+		return string(hash)
+	}
+
+	for _, rel := range relations {
+		if stale[rel.owner] {
+			continue
+		}
+
+		dangling := DanglingRecord{Key: rel.key, Path: pathOf(rel.owner), Relation: rel.relation, Event: rel.event, Other: pathOf(rel.other)}
+
+		if rel.relation == SATISFIES {
+			// The records of a dependent in another project are in its own database.
+			inverse := strings.Join([]string{string(rel.other), string(REQUIRES), string(rel.event), string(rel.owner)}, KEY_SEPARATOR)
+			if stale[rel.other] || (len(keys[rel.other]) > 0 && !requires[inverse]) {
+				g.Dangling = append(g.Dangling, dangling)
+			}
+		} else if stale[rel.other] {
+			g.Dangling = append(g.Dangling, dangling)
+			rebuild[rel.owner] = true
+		}
+	}
+
+	for hash := range rebuild {
+		g.Rebuild = append(g.Rebuild, pathOf(hash))
+		g.rebuildKeys = append(g.rebuildKeys, (&File{PathHash: hash}).mustRebuildKey())
+	}
+	sort.Strings(g.Rebuild)
+
+	redoDir := filepath.Join(rootDir, REDO_DIR)
+
+	infos, err := ioutil.ReadDir(filepath.Join(redoDir, LOG_DIR))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if hash := Hash(info.Name()); stale[hash] || len(keys[hash]) == 0 {
+			g.BuildLogs = append(g.BuildLogs, filepath.Join(redoDir, LOG_DIR, info.Name()))
+		}
+	}
+
+	g.BuildInProgress, err = buildInProgress(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !g.BuildInProgress {
+		config, err := LoadConfig(rootDir)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := config.tempDir(rootDir)
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		// The directory may be shared, so only the files that redo creates are considered.
+		for _, info := range infos {
+			name := info.Name()
+			if (strings.Contains(name, "-redo-tmp-") || strings.HasSuffix(name, "-redo-declared")) && info.ModTime().Before(start) {
+				g.TempFiles = append(g.TempFiles, filepath.Join(dir, name))
+			}
+		}
+	}
+
+	return g, nil
+}
+
+type staleFilesByPath []StaleFile
+
+func (a staleFilesByPath) Len() int           { return len(a) }
+func (a staleFilesByPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a staleFilesByPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+
+// staleFile returns true if the file at path, relative to rootDir, does not exist and has no do file.
+func staleFile(rootDir string, path string) (bool, error) {
+	if _, err := os.Lstat(filepath.Join(rootDir, path)); err == nil || !os.IsNotExist(err) {
+		return false, err
+	}
+
+	f, err := NewFile(rootDir, path)
+	if err != nil {
+		return false, err
+	}
+
+	doInfo, err := f.findDoFile()
+	if err != nil {
+		return false, err
+	}
+
+	return doInfo.Name == "", nil
+}
+
+// buildInProgress returns true if any target in the project in rootDir is locked.
+func buildInProgress(rootDir string) (bool, error) {
+	dir := filepath.Join(rootDir, REDO_DIR, LOCK_DIR)
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, info := range infos {
+		file, err := os.Open(filepath.Join(dir, info.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return false, err
+		}
+
+		locked, err := tryLockFile(file)
+		if locked {
+			unlockFile(file)
+		}
+		file.Close()
+
+		if err != nil {
+			return false, err
+		} else if !locked {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Collect removes the garbage. The records are removed, and targets flagged for rebuild, in a single batch.
+// Since a build changes the records that the garbage was found in, Collect fails if a build is in progress.
+func (g *Garbage) Collect(db DB) error {
+	if inProgress, err := buildInProgress(g.rootDir); err != nil {
+		return err
+	} else if inProgress {
+		return fmt.Errorf("a build is in progress. Run gc when it has finished")
+	}
+
+	batch := new(Batch)
+
+	for _, f := range g.Files {
+		for _, key := range f.Keys {
+			batch.Delete(key)
+		}
+	}
+
+	for _, rec := range g.Dangling {
+		batch.Delete(rec.Key)
+	}
+
+	for _, key := range g.rebuildKeys {
+		batch.Put(key, []byte("null"))
+	}
+
+	if err := db.Write(batch); err != nil {
+		return err
+	}
+
+	for _, paths := range [][]string{g.BuildLogs, g.TempFiles} {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if fileDb, ok := db.(*FileDb); ok {
+		return fileDb.removeEmptyDirs()
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NodeKind classifies the files in a dependency graph.
+type NodeKind string
+
+const (
+	SOURCE NodeKind = "source" // a file that is not built by a do script.
+	TARGET NodeKind = "target" // a file built by a do script.
+	TASK   NodeKind = "task"   // a do script that is run for its side effects.
+)
+
+// A GraphNode is a file in the dependency graph.
+type GraphNode struct {
+	Path string
+	Kind NodeKind
+}
+
+// A GraphEdge is a dependency from one file to its prerequisite.
+type GraphEdge struct {
+	From  string
+	To    string
+	Event Event
+}
+
+// A Graph is the dependency graph recorded in a database.
+// Paths are relative to the root directory. Nodes are sorted by path
+// and edges by their From, To and Event fields.
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// LoadGraph builds the dependency graph from the requires and satisfies records in the database.
+func LoadGraph(db DB) (*Graph, error) {
+	records, err := db.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tasks do not have metadata records, so their paths are recovered
+	// from the satisfies records of their prerequisites.
+	paths := make(map[string]string)
+	kinds := make(map[string]NodeKind)
+
+	type requires struct {
+		from  string
+		to    string
+		event Event
+	}
+	var relations []requires
+
+	for _, rec := range records {
+		parts := strings.Split(rec.Key, KEY_SEPARATOR)
+
+		if len(parts) == 2 && parts[1] == "METADATA" {
+			var m Metadata
+			if err := json.Unmarshal(rec.Value, &m); err != nil {
+				return nil, err
+			}
+			paths[parts[0]] = m.Path
+			if m.HasDoFile() {
+				kinds[m.Path] = TARGET
+			}
+			continue
+		}
+
+		if len(parts) < 4 {
+			continue
+		}
+
+		var value struct{ Path string }
+		relation := Relation(parts[1])
+
+		if relation != REQUIRES && relation != SATISFIES {
+			continue
+		}
+
+		if err := json.Unmarshal(rec.Value, &value); err != nil {
+			return nil, err
+		}
+
+		if relation == SATISFIES {
+			paths[parts[len(parts)-1]] = value.Path
+		} else {
+			event := Event(strings.Join(parts[2:len(parts)-1], KEY_SEPARATOR))
+			relations = append(relations, requires{from: parts[0], to: value.Path, event: event})
+		}
+	}
+
+	g := new(Graph)
+	nodes := make(map[string]bool)
+
+	addNode := func(path string) {
+		if nodes[path] {
+			return
+		}
+		nodes[path] = true
+
+		kind := kinds[path]
+		if kind == "" {
+			kind = SOURCE
+		} else if base := filepath.Base(path); strings.HasPrefix(base, TASK_PREFIX) || base == DEFAULT_TARGET {
+			kind = TASK
+		}
+		g.Nodes = append(g.Nodes, GraphNode{Path: path, Kind: kind})
+	}
+
+	for _, rel := range relations {
+		from, ok := paths[rel.from]
+		if !ok {
+			// orphaned record; nothing points back to the dependent.
+			continue
+		}
+		kinds[from] = TARGET
+		g.Edges = append(g.Edges, GraphEdge{From: from, To: rel.to, Event: rel.event})
+	}
+
+	for _, edge := range g.Edges {
+		addNode(edge.From)
+		addNode(edge.To)
+	}
+
+	for _, path := range paths {
+		addNode(path)
+	}
+
+	g.sort()
+
+	return g, nil
+}
+
+// Subgraph returns the part of the graph that is reachable from the given paths.
+func (g *Graph) Subgraph(paths ...string) *Graph {
+	edges := make(map[string][]GraphEdge)
+	for _, edge := range g.Edges {
+		edges[edge.From] = append(edges[edge.From], edge)
+	}
+
+	seen := make(map[string]bool)
+	sub := new(Graph)
+
+	var walk func(string)
+	walk = func(path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		for _, edge := range edges[path] {
+			sub.Edges = append(sub.Edges, edge)
+			walk(edge.To)
+		}
+	}
+
+	for _, path := range paths {
+		walk(path)
+	}
+
+	for _, node := range g.Nodes {
+		if seen[node.Path] {
+			sub.Nodes = append(sub.Nodes, node)
+		}
+	}
+
+	sub.sort()
+
+	return sub
+}
+
+// Requires returns the edges from the node with the given path.
+func (g *Graph) Requires(path string) []GraphEdge {
+	var out []GraphEdge
+	for _, edge := range g.Edges {
+		if edge.From == path {
+			out = append(out, edge)
+		}
+	}
+	return out
+}
+
+func (g *Graph) sort() {
+	sort.Sort(nodesByPath(g.Nodes))
+	sort.Sort(edgesByPath(g.Edges))
+}
+
+type nodesByPath []GraphNode
+
+func (a nodesByPath) Len() int           { return len(a) }
+func (a nodesByPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a nodesByPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+
+type edgesByPath []GraphEdge
+
+func (a edgesByPath) Len() int      { return len(a) }
+func (a edgesByPath) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a edgesByPath) Less(i, j int) bool {
+	if a[i].From != a[j].From {
+		return a[i].From < a[j].From
+	}
+	if a[i].To != a[j].To {
+		return a[i].To < a[j].To
+	}
+	return a[i].Event < a[j].Event
+}
@@ -1,26 +1,127 @@
 package redux
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/gyepisam/fileutils"
 )
 
-// InitDir creates a redo directory in the specified project root directory.
+// InitDir creates a redo directory in the specified project root directory
+// and writes a default configuration file, unless one exists.
 func InitDir(dirname string) error {
+	rootdir, err := initDir(dirname)
+	if err != nil {
+		return err
+	}
+
+	if exists, err := fileutils.FileExists(configPath(rootdir)); err != nil || exists {
+		return err
+	}
+
+	c := DefaultConfig()
+	c.DBType, err = DBType(rootdir)
+	if err != nil {
+		return err
+	}
+
+	return WriteConfig(rootdir, c)
+}
+
+// InitDirDB creates a redo directory, as InitDir does, and configures it to use a database of the specified type.
+// An existing database of a different type is converted and removed.
+func InitDirDB(dirname string, dbType string) error {
+	if dbType != FILE_DB && dbType != LOG_DB {
+		return fmt.Errorf("unknown database type: %s. Expected %s or %s", dbType, FILE_DB, LOG_DB)
+	}
+
+	rootdir, err := initDir(dirname)
+	if err != nil {
+		return err
+	}
+
+	c, err := readConfig(rootdir)
+	if err != nil {
+		return err
+	}
+
+	if err := convertDB(rootdir, dbType); err != nil {
+		return err
+	}
+
+	c.DBType = dbType
+	return WriteConfig(rootdir, c)
+}
+
+// convertDB copies the records of the database found in the root directory to a new database of the specified type
+// and removes the old database.
+func convertDB(rootdir string, dbType string) error {
+	current, err := DBType(rootdir)
+	if err != nil {
+		return err
+	}
+
+	if dbType == current {
+		return nil
+	}
+
+	db, err := openDB(rootdir, current)
+	if err != nil {
+		return err
+	}
+
+	records, err := db.GetAllRecords()
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	redodir := filepath.Join(rootdir, REDO_DIR)
+
+	if dbType == LOG_DB {
+		if err := LogDbCreate(rootdir, records); err != nil {
+			return err
+		}
+
+		return os.RemoveAll(filepath.Join(redodir, DATA_DIR))
+	}
+
+	db, err = FileDbOpen(rootdir)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		if err := db.Put(rec.Key, rec.Value); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{LOG_DB_FILE, LOG_DB_FILE + ".lock"} {
+		if err := os.Remove(filepath.Join(redodir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func initDir(dirname string) (string, error) {
 
 	if len(dirname) == 0 {
 		wd, err := os.Getwd()
 		if err != nil {
-			return err
+			return "", err
 		}
 		dirname = wd
 	} else if c := dirname[0]; c != '.' && c != '/' {
 		wd, err := os.Getwd()
 		if err != nil {
-			return err
+			return "", err
 		}
 		dirname = filepath.Join(wd, dirname)
 	}
 
-	return os.MkdirAll(filepath.Join(dirname, REDO_DIR), DIR_PERM)
+	return dirname, os.MkdirAll(filepath.Join(dirname, REDO_DIR), DIR_PERM)
 }
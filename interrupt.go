@@ -0,0 +1,145 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Since each do script runs in a process group of its own, a terminal interrupt only reaches
+// the top level redo process. On SIGINT or SIGTERM, redo forwards the signal to the process groups
+// of the scripts it is running, and so to any nested redo processes, which do the same.
+// The scripts have until the kill delay expires to exit before they are killed. Meanwhile, no new scripts are started
+// and the interrupted builds fail in the usual way, removing their temporary outputs and leaving their
+// targets flagged for rebuild. A process that is not building anything exits immediately.
+
+// An InterruptError is returned when a do script is stopped because redo was interrupted.
+type InterruptError struct {
+	Signal os.Signal
+}
+
+func (e *InterruptError) Error() string {
+	return fmt.Sprintf("interrupted by %s", e.Signal)
+}
+
+var interrupts = struct {
+	sync.Mutex
+	once    sync.Once
+	signal  os.Signal
+	builds  int // number of do files being run.
+	scripts map[*exec.Cmd]bool
+}{scripts: make(map[*exec.Cmd]bool)}
+
+// interruptSignal returns the signal that interrupted the process, if any.
+func interruptSignal() os.Signal {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	return interrupts.signal
+}
+
+// Interrupted returns true if the process has been interrupted.
+func Interrupted() bool {
+	return interruptSignal() != nil
+}
+
+// beginBuild is called before a do file is run and its temporary outputs are created.
+// It returns an error if the process has been interrupted.
+func beginBuild() error {
+	interrupts.once.Do(func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go handleInterrupts(c)
+	})
+
+	interrupts.Lock()
+	defer interrupts.Unlock()
+
+	if interrupts.signal != nil {
+		return &InterruptError{interrupts.signal}
+	}
+
+	interrupts.builds++
+	return nil
+}
+
+// endBuild is called once a do file has run and its temporary outputs have been removed.
+func endBuild() {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	interrupts.builds--
+}
+
+// startScript starts the command, unless the process has been interrupted.
+func startScript(cmd *exec.Cmd) error {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+
+	if interrupts.signal != nil {
+		return &InterruptError{interrupts.signal}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	interrupts.scripts[cmd] = true
+	return nil
+}
+
+// stopScript is called once the command has completed.
+func stopScript(cmd *exec.Cmd) {
+	interrupts.Lock()
+	defer interrupts.Unlock()
+	delete(interrupts.scripts, cmd)
+}
+
+func handleInterrupts(c chan os.Signal) {
+	for sig := range c {
+		interrupts.Lock()
+
+		if interrupts.builds == 0 {
+			os.Exit(exitCode(sig))
+		}
+
+		first := interrupts.signal == nil
+		interrupts.signal = sig
+
+		for cmd := range interrupts.scripts {
+			signalGroup(cmd, sig)
+		}
+
+		interrupts.Unlock()
+
+		if first {
+			go killScripts()
+		}
+	}
+}
+
+// killScripts kills the scripts that are still running once the kill delay expires after an interrupt.
+func killScripts() {
+	time.Sleep(killDelay())
+
+	interrupts.Lock()
+	defer interrupts.Unlock()
+
+	for cmd := range interrupts.scripts {
+		signalGroup(cmd, syscall.SIGKILL)
+	}
+}
+
+// exitCode returns the conventional exit status of a process that is terminated by the signal.
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,99 @@
+// +build !windows
+
+package redux
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// initJobServer joins the job server advertised in MAKEFLAGS, if it is usable,
+// otherwise it creates a new one when more than one job is allowed.
+func initJobServer() *JobServer {
+	if auth := jobServerAuth(); auth != "" {
+		js, err := joinJobServer(auth)
+		if err == nil {
+			debugJobServer("joined job server %s\n", auth)
+			return js
+		}
+		debugJobServer("cannot join job server %s: %s\n", auth, err)
+	}
+
+	if Jobs < 2 {
+		return nil
+	}
+
+	js, err := newJobServer(Jobs)
+	if err != nil {
+		debugJobServer("cannot create job server: %s\n", err)
+		return nil
+	}
+
+	debugJobServer("created job server for %d jobs\n", Jobs)
+	return js
+}
+
+func joinJobServer(auth string) (*JobServer, error) {
+	if strings.HasPrefix(auth, "fifo:") {
+		f, err := os.OpenFile(auth[len("fifo:"):], os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &JobServer{r: f, w: f, auth: auth}, nil
+	}
+
+	fds := strings.Split(auth, ",")
+	if len(fds) != 2 {
+		return nil, fmt.Errorf("invalid value")
+	}
+
+	var files [2]*os.File
+
+	for i, s := range fds {
+		fd, err := strconv.Atoi(s)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor %q", s)
+		}
+
+		// make closes the descriptors for commands it does not consider to be recursive,
+		// in which case they may be closed or reused.
+		var stat syscall.Stat_t
+		if err := syscall.Fstat(fd, &stat); err != nil {
+			return nil, fmt.Errorf("file descriptor %d: %s", fd, err)
+		} else if stat.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+			return nil, fmt.Errorf("file descriptor %d is not a pipe", fd)
+		}
+
+		files[i] = os.NewFile(uintptr(fd), "jobserver")
+	}
+
+	return &JobServer{r: files[0], w: files[1]}, nil
+}
+
+// setupCmd passes the job server to the command and sets its MAKEFLAGS entry in env.
+func (js *JobServer) setupCmd(cmd *exec.Cmd, env map[string]string) {
+	if js == nil {
+		return
+	}
+
+	if js.auth != "" {
+		env["MAKEFLAGS"] = makeflags(js.auth)
+		return
+	}
+
+	// Child file descriptors for ExtraFiles entries start at 3.
+	r := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, js.r, js.w)
+	env["MAKEFLAGS"] = makeflags(fmt.Sprintf("%d,%d", r, r+1))
+}
+
+func debugJobServer(format string, args ...interface{}) {
+	if Debug {
+		fmt.Fprintf(os.Stderr, "%s: @JobServer ", os.Args[0])
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
@@ -0,0 +1,16 @@
+// +build windows
+
+package redux
+
+import (
+	"os/exec"
+)
+
+// The job server protocol relies on inherited file descriptors,
+// which are not supported on windows, so the Jobs option applies to each process separately.
+func initJobServer() *JobServer {
+	return nil
+}
+
+func (js *JobServer) setupCmd(cmd *exec.Cmd, env map[string]string) {
+}
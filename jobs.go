@@ -0,0 +1,119 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"strings"
+	"sync"
+)
+
+// RunJobs calls fn with each index in the range [0, n), running up to Jobs calls concurrently.
+// When the process has a job server, the calls share its budget instead.
+// Once a call fails, no new calls are started, unless the KeepGoing option is set.
+// No new calls are started once the process is interrupted.
+// RunJobs waits for the calls in progress to complete and returns the first error or,
+// with KeepGoing, an error that combines every error.
+func RunJobs(n int, fn func(int) error) error {
+
+	js := getJobServer()
+
+	limit := Jobs
+	if js != nil {
+		limit = n
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+
+	if limit == 1 || n < 2 {
+		var errs jobErrors
+		for i := 0; i < n && !Interrupted(); i++ {
+			if err := fn(i); err != nil {
+				if !KeepGoing {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+		return errs.err()
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs jobErrors
+	)
+
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0 && !KeepGoing || Interrupted()
+	}
+
+	slots := make(chan struct{}, limit)
+
+	for i := 0; i < n; i++ {
+		slots <- struct{}{}
+		if failed() {
+			<-slots
+			break
+		}
+
+		token, err := js.Acquire()
+		if err != nil {
+			setErr(err)
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, token jobToken) {
+			defer func() {
+				if err := js.Release(token); err != nil {
+					setErr(err)
+				}
+				<-slots
+				wg.Done()
+			}()
+
+			if err := fn(i); err != nil {
+				setErr(err)
+			}
+		}(i, token)
+	}
+
+	wg.Wait()
+
+	return errs.err()
+}
+
+// jobErrors combines the errors of failed jobs.
+type jobErrors []error
+
+func (errs jobErrors) Error() string {
+	s := make([]string, len(errs))
+	for i, err := range errs {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// err returns nil if there are no errors, the only error if there is one, and errs otherwise.
+func (errs jobErrors) err() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
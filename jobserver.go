@@ -0,0 +1,225 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+A JobServer shares a single parallelism budget among all the processes in a build,
+using the GNU make jobserver protocol.
+
+The server is a pipe which initially holds one token, a single byte, for each job
+that may run in addition to the first. A process reads a token from the pipe before
+starting an extra job and writes it back when the job is done. Every process also owns
+an implicit token, which is the job slot its parent used to run it, so it can always
+run one job without reading from the pipe.
+
+The pipe is advertised to child processes in the MAKEFLAGS environment variable as
+--jobserver-auth=R,W, where R and W are the inherited file descriptors of the pipe ends,
+so nested redo processes and make share the same budget. A redo process started by make,
+or by another redo process, joins the job server it inherits.
+*/
+type JobServer struct {
+	r, w *os.File
+
+	// auth is the --jobserver-auth value for a named pipe.
+	// It is empty when the pipe is passed by file descriptor.
+	auth string
+
+	implicit chan struct{}
+
+	mu      sync.Mutex
+	waiters []chan jobResult // Acquire calls waiting for a token from the pipe, in order.
+	reading bool             // true while the reader is running.
+}
+
+type jobResult struct {
+	token jobToken
+	err   error
+}
+
+// A jobToken is the byte read from the job server, which must be returned unchanged.
+type jobToken struct {
+	value    byte
+	fromPipe bool
+}
+
+var (
+	jobServerOnce sync.Once
+	jobServerInst *JobServer
+)
+
+// getJobServer returns the process' job server, if any.
+// It is initialized upon first use so that the Jobs option can be set beforehand.
+func getJobServer() *JobServer {
+	jobServerOnce.Do(func() {
+		jobServerInst = initJobServer()
+		if jobServerInst != nil {
+			jobServerInst.implicit = make(chan struct{}, 1)
+			jobServerInst.implicit <- struct{}{}
+		}
+	})
+	return jobServerInst
+}
+
+// newJobServer creates a job server for the given number of jobs.
+func newJobServer(jobs int) (*JobServer, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write([]byte(strings.Repeat("+", jobs-1))); err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+
+	return &JobServer{r: r, w: w}, nil
+}
+
+// Acquire returns a token for a job, waiting for one if necessary.
+// The implicit token is used when it is available or as soon as it becomes available,
+// whichever happens before a token can be read from the pipe.
+func (js *JobServer) Acquire() (jobToken, error) {
+	if js == nil {
+		return jobToken{}, nil
+	}
+
+	select {
+	case <-js.implicit:
+		return jobToken{}, nil
+	default:
+	}
+
+	got := make(chan jobResult, 1)
+
+	js.mu.Lock()
+	js.waiters = append(js.waiters, got)
+	if !js.reading {
+		js.reading = true
+		go js.read()
+	}
+	js.mu.Unlock()
+
+	select {
+	case res := <-got:
+		if res.err != nil {
+			return jobToken{}, fmt.Errorf("cannot read job server token: %s", res.err)
+		}
+		return res.token, nil
+	case <-js.implicit:
+	}
+
+	js.mu.Lock()
+	waiting := false
+	for i, ch := range js.waiters {
+		if ch == got {
+			js.waiters = append(js.waiters[:i], js.waiters[i+1:]...)
+			waiting = true
+			break
+		}
+	}
+	js.mu.Unlock()
+
+	// The reader handed over a token from the pipe as the implicit token became available.
+	// The pipe token is returned before Acquire does, so that it cannot be lost if the process exits.
+	if !waiting {
+		if res := <-got; res.err == nil {
+			if err := js.Release(res.token); err != nil {
+				js.implicit <- struct{}{}
+				return jobToken{}, err
+			}
+		}
+	}
+
+	return jobToken{}, nil
+}
+
+// read reads tokens from the pipe, one at a time, for the waiting Acquire calls and exits once there are none.
+// A single reader serves every call, so calls that take the implicit token instead leave no reads behind.
+// A token that arrives after its caller has taken the implicit token is written back at once.
+func (js *JobServer) read() {
+	for {
+		b := make([]byte, 1)
+		_, err := js.r.Read(b)
+		res := jobResult{jobToken{value: b[0], fromPipe: true}, err}
+
+		js.mu.Lock()
+
+		if len(js.waiters) == 0 {
+			js.reading = false
+			js.mu.Unlock()
+			if err == nil {
+				js.Release(res.token)
+			}
+			return
+		}
+
+		js.waiters[0] <- res
+		js.waiters = js.waiters[1:]
+
+		if len(js.waiters) == 0 {
+			js.reading = false
+			js.mu.Unlock()
+			return
+		}
+
+		js.mu.Unlock()
+	}
+}
+
+// Release returns the token to the job server.
+func (js *JobServer) Release(token jobToken) error {
+	if js == nil {
+		return nil
+	}
+
+	if !token.fromPipe {
+		js.implicit <- struct{}{}
+		return nil
+	}
+
+	_, err := js.w.Write([]byte{token.value})
+	return err
+}
+
+// makeflags returns MAKEFLAGS for a child process that reaches the job server through auth.
+// Any job server options in the current value are replaced.
+func makeflags(auth string) string {
+	var flags []string
+	for _, word := range strings.Fields(os.Getenv("MAKEFLAGS")) {
+		if !isJobServerFlag(word) {
+			flags = append(flags, word)
+		}
+	}
+
+	return strings.Join(append(flags, "-j", "--jobserver-auth="+auth), " ")
+}
+
+func isJobServerFlag(word string) bool {
+	return strings.HasPrefix(word, "--jobserver-auth=") ||
+		strings.HasPrefix(word, "--jobserver-fds=") ||
+		strings.HasPrefix(word, "-j")
+}
+
+// jobServerAuth returns the job server value advertised in MAKEFLAGS, if any.
+// The last value wins.
+func jobServerAuth() string {
+	var auth string
+	for _, word := range strings.Fields(os.Getenv("MAKEFLAGS")) {
+		for _, prefix := range []string{"--jobserver-auth=", "--jobserver-fds="} {
+			if strings.HasPrefix(word, prefix) {
+				auth = word[len(prefix):]
+			}
+		}
+	}
+	return auth
+}
@@ -0,0 +1,78 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"testing"
+	"time"
+)
+
+// A call that takes the implicit token while it waits for the pipe leaves nothing behind
+// and a token that arrives from the pipe later is written back for others to use.
+func TestJobServerImplicitToken(t *testing.T) {
+	js, err := newJobServer(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.r.Close()
+	defer js.w.Close()
+
+	js.implicit = make(chan struct{}, 1)
+	js.implicit <- struct{}{}
+
+	first, err := js.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan jobToken)
+	go func() {
+		token, err := js.Acquire()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- token
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			js.mu.Lock()
+			ok := cond()
+			js.mu.Unlock()
+			if ok {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+
+	waitFor("a waiting call", func() bool { return len(js.waiters) == 1 })
+
+	if err := js.Release(first); err != nil {
+		t.Fatal(err)
+	}
+
+	if second := <-done; second.fromPipe {
+		t.Errorf("want the implicit token")
+	}
+
+	if n := len(js.waiters); n != 0 {
+		t.Errorf("want no waiting calls, got %d", n)
+	}
+
+	// Another process releases a token, which the reader takes and writes back.
+	if _, err := js.w.Write([]byte("+")); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor("the reader to exit", func() bool { return !js.reading })
+
+	third, err := js.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	} else if !third.fromPipe {
+		t.Errorf("want the token from the pipe")
+	}
+}
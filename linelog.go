@@ -0,0 +1,47 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// newTempLog creates an empty temporary log, whose name begins with prefix, and sets the environment
+// variable env so that the current process and its children append to it. It returns the path to the log.
+func newTempLog(prefix string, env string) (string, error) {
+	file, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return file.Name(), os.Setenv(env, file.Name())
+}
+
+// appendLine appends the JSON encoding of v, followed by a newline, to the file at path.
+// The file is shared by the processes of a build. Each line is written in a single append,
+// which is atomic for a small write, so concurrent processes do not interleave their lines.
+func appendLine(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(b, '\n'))
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// AllMetadata returns the metadata records for every file in the database, sorted by path.
+func AllMetadata(db DB) ([]Metadata, error) {
+	records, err := db.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := KEY_SEPARATOR + "METADATA"
+
+	var out []Metadata
+	for _, rec := range records {
+		if !strings.HasSuffix(rec.Key, suffix) {
+			continue
+		}
+
+		var m Metadata
+		if err := json.Unmarshal(rec.Value, &m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+
+	sort.Sort(byPath(out))
+
+	return out, nil
+}
+
+type byPath []Metadata
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
@@ -0,0 +1,175 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Where are target locks kept?
+	LOCK_DIR = "lock"
+)
+
+// A Lock is an advisory lock on a target, which is held while the target is being built.
+// It excludes other redo processes, as well as other jobs in the same process,
+// from building the target at the same time.
+//
+// A lock is a file, named for the target's PathHash, in the .redo/lock directory.
+// The file contains the process id of its owner and is removed when the lock is released.
+// The operating system releases the lock when its owner exits, so a lock file left behind
+// by a crashed process is stale and is reclaimed by the next process that needs it.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+func (f *File) lockPath() string {
+	return filepath.Join(f.RedoDir(), LOCK_DIR, string(f.PathHash))
+}
+
+// pendingID identifies the target in the REDO_PENDING environment variable.
+func (f *File) pendingID() string {
+	return ";" + string(f.FullPathHash)
+}
+
+// checkPending returns an error if the target is already being built by an ancestor process.
+func (f *File) checkPending() error {
+	pending := os.Getenv("REDO_PENDING")
+	f.Debug("Current: [%s]. Pending: [%s].\n", f.pendingID(), pending)
+	if strings.Contains(pending, f.pendingID()) {
+		return fmt.Errorf("Loop detected on pending target: %s", f.Target)
+	}
+	return nil
+}
+
+// Lock acquires the target's lock, waiting for the current owner, if any, to release it.
+// A file without a database cannot be built and is not locked.
+func (f *File) Lock() (*Lock, error) {
+
+	// An ancestor holds the lock and waiting for it would never end.
+	if err := f.checkPending(); err != nil {
+		return nil, err
+	}
+
+	if f.HasNullDb() {
+		return &Lock{}, nil
+	}
+
+	path := f.lockPath()
+	if err := os.MkdirAll(filepath.Dir(path), DIR_PERM); err != nil {
+		return nil, err
+	}
+
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if locked, err := tryLockFile(file); err != nil {
+			file.Close()
+			return nil, err
+		} else if !locked {
+			if Verbose() {
+				f.Log("%s: waiting for lock held by process %s\n", f.Target, lockOwner(file))
+			}
+			if err := lockFile(file); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+
+		// The previous owner removes the lock file upon release, so the file may be gone
+		// or replaced by the time the lock is acquired. If so, start again.
+		if same, err := sameFile(file, path); err != nil {
+			file.Close()
+			return nil, err
+		} else if !same {
+			file.Close()
+			continue
+		}
+
+		if owner := lockOwner(file); owner != "" {
+			f.Debug("@Lock reclaimed stale lock held by process %s\n", owner)
+		}
+
+		if err := writeLockOwner(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		f.Debug("@Lock %s\n", path)
+
+		lock := &Lock{path: path, file: file}
+
+		// No other process builds the file while the lock is held, so a transaction
+		// interrupted by the death of a previous owner can be completed without replacing newer records.
+		if err := f.replayJournals(); err != nil {
+			lock.Unlock()
+			return nil, err
+		}
+
+		return lock, nil
+	}
+}
+
+// Unlock removes the lock file and releases the lock.
+func (l *Lock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := os.Remove(l.path)
+	if e := l.file.Close(); err == nil {
+		err = e
+	}
+
+	l.file = nil
+
+	return err
+}
+
+// lockOwner returns the process id stored in the lock file, if any.
+func lockOwner(file *os.File) string {
+	if _, err := file.Seek(0, 0); err != nil {
+		return ""
+	}
+	b, err := ioutil.ReadAll(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func writeLockOwner(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// sameFile reports whether the open file is still the one at path.
+func sameFile(file *os.File, path string) (bool, error) {
+	openInfo, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	pathInfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return os.SameFile(openInfo, pathInfo), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A second lock on a target waits for the first to be released
+// and a lock file left behind by a dead process does not block anyone.
+func TestLock(t *testing.T) {
+	root, fn, err := initRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fn()
+
+	f, err := NewFile(root, "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stale lock
+	if err := os.MkdirAll(filepath.Dir(f.lockPath()), DIR_PERM); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(f.lockPath(), []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := f.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *Lock)
+	go func() {
+		second, err := f.Lock()
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- second
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lock acquired while first lock is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case second := <-acquired:
+		if err := second.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lock not acquired after first lock was released")
+	}
+
+	if _, err := os.Stat(f.lockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock file %s not removed: %v", f.lockPath(), err)
+	}
+}
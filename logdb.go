@@ -0,0 +1,583 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// LOG_DB_FILE names the single file LogDb database in the redo directory.
+	// Its presence selects the LogDb backend for the project.
+	LOG_DB_FILE = "data.db"
+
+	// logDbMagic is the header of a LogDb file.
+	logDbMagic = "redux log db 2\n"
+
+	// Log records consist of an operation byte, the key and value lengths, a checksum of those,
+	// the key, the value and a checksum of everything before it. Since the lengths are checked
+	// on their own, a record that extends past the end of the file is known to be the last one.
+	logHeaderSize  = 1 + 4 + 4 + 4
+	logTrailerSize = 4
+
+	logPut    byte = 'P'
+	logDelete byte = 'D'
+
+	// The value of a batch record holds the put and delete records of a batch,
+	// so that its checksum covers them all and they are applied together or not at all.
+	logBatch byte = 'B'
+
+	// A log is compacted when it is larger than logCompactSize and
+	// more than half of it consists of superseded records.
+	logCompactSize = 1 << 20
+)
+
+var logDbCorruptErr = errors.New("LogDb file is corrupt.")
+
+// LogDb is a single file, log structured DB for storing Redo relationships and metadata.
+//
+// Every change is appended to the file as a record, and a batch of changes as a single record
+// that contains them. An in-memory index, sorted by key, holds the current value of each key
+// and is kept up to date by reading any records appended by other processes before each operation. Processes coordinate with file locks:
+// readers share the lock and writers hold it exclusively. When the file accumulates
+// enough superseded records, a writer rewrites it with only the current values.
+//
+// A process shares a single LogDb per project among all its files and goroutines.
+type LogDb struct {
+	path string
+
+	mu       sync.Mutex
+	lockFile *os.File // never replaced, unlike the data file.
+	file     *os.File
+	info     os.FileInfo // identifies file, to detect compaction by other processes.
+	offset   int64       // end of the last record read.
+	garbage  int64       // size of superseded records.
+	values   map[string][]byte
+	keys     []string // sorted
+}
+
+var logDbs = struct {
+	sync.Mutex
+	m map[string]*LogDb
+}{m: make(map[string]*LogDb)}
+
+// LogDbOpen requires a project root argument
+func LogDbOpen(rootdir string) (DB, error) {
+	path := filepath.Join(rootdir, REDO_DIR, LOG_DB_FILE)
+
+	logDbs.Lock()
+	defer logDbs.Unlock()
+
+	if db, ok := logDbs.m[path]; ok {
+		return db, nil
+	}
+
+	db, err := newLogDb(path)
+	if err != nil {
+		return nil, err
+	}
+
+	logDbs.m[path] = db
+
+	return db, nil
+}
+
+func newLogDb(path string) (*LogDb, error) {
+	db := &LogDb{path: path}
+
+	var err error
+	db.lockFile, err = os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("LogDb cannot open lock file. %s", err)
+	}
+
+	if err := db.open(); err != nil {
+		db.lockFile.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// LogDbCreate creates a LogDb file containing the records in the project root directory.
+// An existing file is replaced.
+func LogDbCreate(rootdir string, records []Record) error {
+	return writeLogFile(filepath.Join(rootdir, REDO_DIR, LOG_DB_FILE), records)
+}
+
+// logDbInit creates an empty LogDb file in the project root directory, unless one exists.
+// The file is linked into place so that it is never seen without its header.
+func logDbInit(rootdir string) error {
+	path := filepath.Join(rootdir, REDO_DIR, LOG_DB_FILE)
+
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+LOG_DB_FILE)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(logDbMagic)
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+
+	if err == nil {
+		if err = os.Link(file.Name(), path); os.IsExist(err) {
+			err = nil
+		}
+	}
+
+	return err
+}
+
+// open opens the data file and resets the index.
+func (db *LogDb) open() error {
+	file, err := os.OpenFile(db.path, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("LogDb cannot open [%s]. %s", db.path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if db.file != nil {
+		db.file.Close()
+	}
+
+	db.file = file
+	db.info = info
+	db.offset = 0
+	db.garbage = 0
+	db.values = make(map[string][]byte)
+	db.keys = nil
+
+	return nil
+}
+
+func (db *LogDb) IsNull() bool { return false }
+
+// Close is a noop since the database is shared by the process.
+func (db *LogDb) Close() error {
+	return nil
+}
+
+// read acquires a shared lock, brings the index up to date and calls fn.
+func (db *LogDb) read(fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := lockFileShared(db.lockFile); err != nil {
+		return err
+	}
+	defer unlockFile(db.lockFile)
+
+	if _, err := db.catchUp(); err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+// write appends a record for a single change.
+func (db *LogDb) write(op byte, key string, value []byte) error {
+	if len(key) == 0 {
+		return NullKeyErr
+	}
+
+	return db.update(func() []byte {
+		if _, ok := db.values[key]; !ok && op == logDelete {
+			return nil
+		}
+		return encodeLogRecord(op, key, value)
+	})
+}
+
+// Write appends the changes in the batch as a single record.
+func (db *LogDb) Write(batch *Batch) error {
+	for _, op := range batch.ops {
+		if len(op.key) == 0 {
+			return NullKeyErr
+		}
+	}
+
+	return db.update(func() []byte {
+		var data []byte
+		count := 0
+		for _, op := range batch.ops {
+			if !op.delete {
+				data = append(data, encodeLogRecord(logPut, op.key, op.value)...)
+			} else if _, ok := db.values[op.key]; ok {
+				data = append(data, encodeLogRecord(logDelete, op.key, nil)...)
+			} else {
+				continue
+			}
+			count++
+		}
+
+		if count <= 1 {
+			return data
+		}
+		return encodeLogRecord(logBatch, "", data)
+	})
+}
+
+// update acquires an exclusive lock, brings the index up to date, appends the record
+// returned by fn, if any, and compacts the file if necessary.
+func (db *LogDb) update(fn func() []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := lockFile(db.lockFile); err != nil {
+		return err
+	}
+	defer unlockFile(db.lockFile)
+
+	torn, err := db.catchUp()
+	if err != nil {
+		return err
+	}
+
+	// A partial last record, left by a process that died while writing it, is discarded.
+	if torn {
+		if err := db.file.Truncate(db.offset); err != nil {
+			return err
+		}
+	}
+
+	rec := fn()
+	if len(rec) == 0 {
+		return nil
+	}
+
+	if _, err := db.file.WriteAt(rec, db.offset); err != nil {
+		return err
+	}
+
+	op, key, value, n, err := decodeLogRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	if err := db.apply(op, key, value, int64(n)); err != nil {
+		return err
+	}
+	db.offset += int64(n)
+
+	if db.offset > logCompactSize && db.garbage*2 > db.offset {
+		return db.compact()
+	}
+
+	return nil
+}
+
+// catchUp applies the records written since the last call.
+// It returns true if the file ends with a partial record. Any other damage is reported as an error.
+func (db *LogDb) catchUp() (torn bool, err error) {
+
+	// Another process may have replaced the file by compacting it.
+	if info, err := os.Stat(db.path); err != nil {
+		return false, err
+	} else if !os.SameFile(info, db.info) {
+		if err := db.open(); err != nil {
+			return false, err
+		}
+	}
+
+	info, err := db.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	size := info.Size()
+	if size <= db.offset {
+		return false, nil
+	}
+
+	data := make([]byte, size-db.offset)
+	if _, err := db.file.ReadAt(data, db.offset); err != nil && err != io.EOF {
+		return false, err
+	}
+
+	if db.offset == 0 {
+		if !strings.HasPrefix(string(data), logDbMagic) {
+			return false, fmt.Errorf("LogDb [%s] has an invalid header", db.path)
+		}
+		data = data[len(logDbMagic):]
+		db.offset = int64(len(logDbMagic))
+	}
+
+	for len(data) > 0 {
+		op, key, value, n, err := decodeLogRecord(data)
+		if err == io.ErrUnexpectedEOF {
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("LogDb [%s] at offset %d: %s", db.path, db.offset, err)
+		}
+
+		if err := db.apply(op, key, value, int64(n)); err != nil {
+			return false, fmt.Errorf("LogDb [%s] at offset %d: %s", db.path, db.offset, err)
+		}
+		db.offset += int64(n)
+		data = data[n:]
+	}
+
+	return false, nil
+}
+
+// apply updates the index with a record of the given size.
+// The records in a batch are applied in turn.
+func (db *LogDb) apply(op byte, key string, value []byte, size int64) error {
+	if op == logBatch {
+		db.garbage += int64(logHeaderSize + logTrailerSize)
+		for len(value) > 0 {
+			op, key, data, n, err := decodeLogRecord(value)
+			if err != nil || op == logBatch {
+				return logDbCorruptErr
+			}
+			db.applyChange(op, key, data, int64(n))
+			value = value[n:]
+		}
+		return nil
+	}
+
+	db.applyChange(op, key, value, size)
+	return nil
+}
+
+// applyChange updates the index with a put or delete record of the given size.
+func (db *LogDb) applyChange(op byte, key string, value []byte, size int64) {
+	old, exists := db.values[key]
+	if exists {
+		db.garbage += int64(logHeaderSize + len(key) + len(old) + logTrailerSize)
+	}
+
+	if op == logDelete {
+		db.garbage += size
+		if exists {
+			delete(db.values, key)
+			i := sort.SearchStrings(db.keys, key)
+			db.keys = append(db.keys[:i], db.keys[i+1:]...)
+		}
+		return
+	}
+
+	db.values[key] = value
+	if !exists {
+		i := sort.SearchStrings(db.keys, key)
+		db.keys = append(db.keys, "")
+		copy(db.keys[i+1:], db.keys[i:])
+		db.keys[i] = key
+	}
+}
+
+// compact replaces the file with one that contains only the current values.
+func (db *LogDb) compact() error {
+	records := make([]Record, len(db.keys))
+	for i, key := range db.keys {
+		records[i] = Record{Key: key, Value: db.values[key]}
+	}
+
+	if err := writeLogFile(db.path, records); err != nil {
+		return err
+	}
+
+	if err := db.open(); err != nil {
+		return err
+	}
+
+	_, err := db.catchUp()
+	return err
+}
+
+// writeLogFile atomically replaces the file at path with a log of the records.
+func writeLogFile(path string, records []Record) error {
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	w.WriteString(logDbMagic)
+	for _, rec := range records {
+		w.Write(encodeLogRecord(logPut, rec.Key, rec.Value))
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
+
+func encodeLogRecord(op byte, key string, value []byte) []byte {
+	n := logHeaderSize + len(key) + len(value)
+	b := make([]byte, n+logTrailerSize)
+	b[0] = op
+	binary.BigEndian.PutUint32(b[1:], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[5:], uint32(len(value)))
+	binary.BigEndian.PutUint32(b[9:], crc32.ChecksumIEEE(b[:9]))
+	copy(b[logHeaderSize:], key)
+	copy(b[logHeaderSize+len(key):], value)
+	binary.BigEndian.PutUint32(b[n:], crc32.ChecksumIEEE(b[:n]))
+	return b
+}
+
+// decodeLogRecord decodes the record at the start of data and returns its size.
+// The error is io.ErrUnexpectedEOF if data ends before the record does, which can only
+// be the case for the last record, and logDbCorruptErr if the record is damaged.
+func decodeLogRecord(data []byte) (op byte, key string, value []byte, size int, err error) {
+	if len(data) < logHeaderSize {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+
+	op = data[0]
+	if op != logPut && op != logDelete && op != logBatch {
+		return 0, "", nil, 0, logDbCorruptErr
+	}
+
+	// Without this check, a damaged length would look like a partial record.
+	if binary.BigEndian.Uint32(data[9:]) != crc32.ChecksumIEEE(data[:9]) {
+		return 0, "", nil, 0, logDbCorruptErr
+	}
+
+	keyLen := int(binary.BigEndian.Uint32(data[1:]))
+	valueLen := int(binary.BigEndian.Uint32(data[5:]))
+	n := logHeaderSize + keyLen + valueLen
+	if keyLen < 0 || valueLen < 0 || n < 0 {
+		return 0, "", nil, 0, logDbCorruptErr
+	}
+
+	if len(data) < n+logTrailerSize {
+		return 0, "", nil, 0, io.ErrUnexpectedEOF
+	}
+
+	if binary.BigEndian.Uint32(data[n:]) != crc32.ChecksumIEEE(data[:n]) {
+		return 0, "", nil, 0, logDbCorruptErr
+	}
+
+	key = string(data[logHeaderSize : logHeaderSize+keyLen])
+	value = make([]byte, valueLen)
+	copy(value, data[logHeaderSize+keyLen:n])
+
+	return op, key, value, n + logTrailerSize, nil
+}
+
+func (db *LogDb) Put(key string, value []byte) error {
+	b := make([]byte, len(value))
+	copy(b, value)
+	return db.write(logPut, key, b)
+}
+
+func (db *LogDb) Get(key string) (value []byte, found bool, err error) {
+	if len(key) == 0 {
+		return nil, false, NullKeyErr
+	}
+
+	err = db.read(func() error {
+		var b []byte
+		if b, found = db.values[key]; found {
+			value = make([]byte, len(b))
+			copy(value, b)
+		}
+		return nil
+	})
+
+	return
+}
+
+func (db *LogDb) Delete(key string) error {
+	return db.write(logDelete, key, nil)
+}
+
+func (db *LogDb) GetRecords(prefix string) ([]Record, error) {
+	if len(prefix) == 0 {
+		return nil, NullPrefixErr
+	}
+
+	return db.scan(prefix)
+}
+
+// GetAllRecords returns a list of all the records in the database.
+func (db *LogDb) GetAllRecords() ([]Record, error) {
+	return db.scan("")
+}
+
+// scan returns the records whose keys begin with prefix, in key order.
+func (db *LogDb) scan(prefix string) (out []Record, err error) {
+	err = db.read(func() error {
+		for i := sort.SearchStrings(db.keys, prefix); i < len(db.keys); i++ {
+			key := db.keys[i]
+			if !strings.HasPrefix(key, prefix) {
+				break
+			}
+			value := make([]byte, len(db.values[key]))
+			copy(value, db.values[key])
+			out = append(out, Record{Key: key, Value: value})
+		}
+		return nil
+	})
+	return
+}
+
+// GetKeys returns an array of keys that are prefixes of the specified key.
+func (db *LogDb) GetKeys(prefix string) ([]string, error) {
+	records, err := db.GetRecords(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, len(records))
+	for i, rec := range records {
+		out[i] = rec.Key
+	}
+	return out, nil
+}
+
+// GetValues returns an array of data values for keys with the specified prefix.
+func (db *LogDb) GetValues(prefix string) ([][]byte, error) {
+	records, err := db.GetRecords(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([][]byte, len(records))
+	for i, rec := range records {
+		out[i] = rec.Value
+	}
+	return out, nil
+}
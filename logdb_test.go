@@ -0,0 +1,394 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func initLogDb(t *testing.T) (root string, fn func()) {
+	root, fn, err := initRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitDirDB(root, LOG_DB); err != nil {
+		fn()
+		t.Fatal(err)
+	}
+
+	return root, fn
+}
+
+func getString(t *testing.T, db DB, key string) string {
+	b, found, err := db.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	} else if !found {
+		return "<missing>"
+	}
+	return string(b)
+}
+
+// Separate instances, like separate processes, see each other's changes.
+func TestLogDbCatchUp(t *testing.T) {
+	root, fn := initLogDb(t)
+	defer fn()
+
+	path := filepath.Join(root, REDO_DIR, LOG_DB_FILE)
+
+	a, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Put("k", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, b, "k"); got != "1" {
+		t.Errorf("want 1, got %s", got)
+	}
+
+	if err := b.Put("k", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Put("k2", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Delete("k2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, a, "k"); got != "2" {
+		t.Errorf("want 2, got %s", got)
+	}
+
+	if got := getString(t, b, "k2"); got != "<missing>" {
+		t.Errorf("want k2 to be deleted, got %s", got)
+	}
+}
+
+// A partial record at the end of the file is ignored and then overwritten.
+func TestLogDbTornRecord(t *testing.T) {
+	root, fn := initLogDb(t)
+	defer fn()
+
+	path := filepath.Join(root, REDO_DIR, LOG_DB_FILE)
+
+	db, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := encodeLogRecord(logPut, "b", []byte("2"))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(rec[:len(rec)-1]); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	other, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, other, "b"); got != "<missing>" {
+		t.Errorf("want partial record to be ignored, got %s", got)
+	}
+
+	if err := other.Put("c", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, db, "c"); got != "3" {
+		t.Errorf("want 3, got %s", got)
+	}
+}
+
+// Compaction preserves the current values and is seen by other instances.
+func TestLogDbCompact(t *testing.T) {
+	root, fn := initLogDb(t)
+	defer fn()
+
+	path := filepath.Join(root, REDO_DIR, LOG_DB_FILE)
+
+	a, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := make([]byte, 1024)
+	for i := 0; i < 4*logCompactSize/len(value); i++ {
+		if err := a.Put(fmt.Sprintf("key/%d", i%10), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Put("last", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() > 2*logCompactSize {
+		t.Errorf("expected file to be compacted. Size: %d", info.Size())
+	}
+
+	keys, err := b.GetKeys("key/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 10 {
+		t.Errorf("want 10 keys, got %d: %v", len(keys), keys)
+	}
+
+	if got := getString(t, b, "last"); got != "value" {
+		t.Errorf("want value, got %s", got)
+	}
+}
+
+// Converting between database types preserves the records.
+func TestDBConvert(t *testing.T) {
+	root, fn, err := initRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fn()
+
+	want := []Record{{"a/1", []byte("one")}, {"a/2", []byte("two")}, {"b", []byte("three")}}
+
+	err = WithDB(root, func(db DB) error {
+		for _, rec := range want {
+			if err := db.Put(rec.Key, rec.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dbType := range []string{LOG_DB, FILE_DB} {
+		if err := InitDirDB(root, dbType); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := DBType(root); err != nil {
+			t.Fatal(err)
+		} else if got != dbType {
+			t.Errorf("want database type %s, got %s", dbType, got)
+		}
+
+		var got []Record
+		err := WithDB(root, func(db DB) (err error) {
+			got, err = db.GetAllRecords()
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %v, got %v", dbType, want, got)
+		}
+	}
+}
+
+// A database of a type other than the configured one is reported, rather than ignored.
+func TestDBTypeMismatch(t *testing.T) {
+	root, fn, err := initRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fn()
+
+	err = WithDB(root, func(db DB) error {
+		return db.Put("k", []byte("v"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dbType := range []string{LOG_DB, FILE_DB} {
+		other := FILE_DB
+		if dbType == FILE_DB {
+			other = LOG_DB
+		}
+
+		c, err := readConfig(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.DBType = dbType
+		if err := WriteConfig(root, c); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := WithDB(root, func(DB) error { return nil }); err == nil {
+			t.Errorf("%s: expected an error opening a %s database", dbType, other)
+		}
+
+		if got, err := DBType(root); err != nil {
+			t.Fatal(err)
+		} else if got != other {
+			t.Errorf("%s: want database type %s, got %s", dbType, other, got)
+		}
+
+		if err := InitDirDB(root, dbType); err != nil {
+			t.Fatal(err)
+		}
+
+		err = WithDB(root, func(db DB) error {
+			if got := getString(t, db, "k"); got != "v" {
+				t.Errorf("%s: want v, got %s", dbType, got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A partial batch record is ignored, so none of its changes are made.
+func TestLogDbTornBatch(t *testing.T) {
+	root, fn := initLogDb(t)
+	defer fn()
+
+	path := filepath.Join(root, REDO_DIR, LOG_DB_FILE)
+
+	db, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	data := append(encodeLogRecord(logDelete, "a", nil), encodeLogRecord(logPut, "b", []byte("2"))...)
+	rec := encodeLogRecord(logBatch, "", data)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(rec[:len(rec)-1]); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	other, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, other, "a"); got != "1" {
+		t.Errorf("want 1, got %s", got)
+	}
+
+	if got := getString(t, other, "b"); got != "<missing>" {
+		t.Errorf("want partial batch to be ignored, got %s", got)
+	}
+
+	batch := new(Batch)
+	batch.Delete("a")
+	batch.Put("b", []byte("3"))
+	if err := other.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getString(t, db, "a"); got != "<missing>" {
+		t.Errorf("want a to be deleted, got %s", got)
+	}
+
+	if got := getString(t, db, "b"); got != "3" {
+		t.Errorf("want 3, got %s", got)
+	}
+}
+
+// A damaged record that is not the last one is reported, rather than discarded with those that follow it.
+func TestLogDbCorruptRecord(t *testing.T) {
+	root, fn := initLogDb(t)
+	defer fn()
+
+	path := filepath.Join(root, REDO_DIR, LOG_DB_FILE)
+
+	db, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put(key, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The value length of the record of b claims that it extends past the end of the file.
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(len(logDbMagic) + len(encodeLogRecord(logPut, "a", []byte("1"))))
+	if _, err := file.WriteAt([]byte{0, 1, 0, 0}, offset+5); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	other, err := newLogDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := other.Get("c"); err == nil {
+		t.Errorf("want an error for a damaged record")
+	}
+
+	if err := other.Put("d", []byte("1")); err == nil {
+		t.Errorf("want an error for a damaged record")
+	}
+
+	if after, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if after.Size() != before.Size() {
+		t.Errorf("want the file to be left alone, size %d became %d", before.Size(), after.Size())
+	}
+}
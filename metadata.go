@@ -4,18 +4,40 @@
 
 package redux
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 // File Metadata.
 type Metadata struct {
 	Path        string //not used for comparison
 	ContentHash Hash
 	DoFile      string
+	Stamp       Hash `json:",omitempty"` // set by redo-stamp. Replaces ContentHash for comparison.
+
+	// File status, used to avoid recomputing the ContentHash of an unchanged file.
+	// Not used for comparison.
+	Size    int64       `json:",omitempty"`
+	ModTime int64       `json:",omitempty"` // nanoseconds since the epoch. Zero if unknown.
+	Inode   uint64      `json:",omitempty"`
+	Mode    os.FileMode `json:",omitempty"`
 }
 
+// A file modified within racyWindow of being examined may be modified again
+// without changing its modification time, so its status is not recorded.
+const racyWindow = 2 * time.Second
+
 // Equal compares metadata instances for equality.
+// Instances that both have stamps are compared by stamp, otherwise they are compared by content.
 func (m *Metadata) Equal(other *Metadata) bool {
-	return other != nil && m.ContentHash == other.ContentHash
+	if other == nil {
+		return false
+	}
+	if len(m.Stamp) > 0 && len(other.Stamp) > 0 {
+		return m.Stamp == other.Stamp
+	}
+	return m.ContentHash == other.ContentHash
 }
 
 // IsCreated compares m to other to determine m represents a newly created file.
@@ -26,8 +48,31 @@ func (m Metadata) IsCreated(other Metadata) bool {
 // NewMetadata returns a metadata instance for the given path.
 // If the file is not found, nil is returned.
 func NewMetadata(path string, storedPath string) (*Metadata, error) {
+	return newMetadata(path, storedPath, DEFAULT_HASH, nil)
+}
+
+// newMetadata returns a metadata instance for the given path, like NewMetadata, whose content
+// hash is computed with the named algorithm. If the file status matches that of the known metadata
+// and the known content hash was computed with the same algorithm, it is reused rather than recomputed.
+func newMetadata(path string, storedPath string, algorithm string, known *Metadata) (*Metadata, error) {
+
+	finfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	hash, err := ContentHash(path)
+	m := &Metadata{Path: storedPath}
+	m.setStat(finfo)
+
+	if known != nil && m.sameStat(known) && known.ContentHash.Algorithm() == algorithm {
+		m.ContentHash = known.ContentHash
+		return m, nil
+	}
+
+	hash, err := FileHash(path, algorithm)
 	if os.IsNotExist(err) {
 		return nil, nil
 	}
@@ -35,7 +80,36 @@ func NewMetadata(path string, storedPath string) (*Metadata, error) {
 		return nil, err
 	}
 
-	return &Metadata{Path: storedPath, ContentHash: hash}, nil
+	m.ContentHash = hash
+
+	return m, nil
+}
+
+// setStat records the file status unless the file was modified too recently to be trusted.
+func (m *Metadata) setStat(finfo os.FileInfo) {
+	if time.Since(finfo.ModTime()) < racyWindow {
+		return
+	}
+	m.Size = finfo.Size()
+	m.ModTime = finfo.ModTime().UnixNano()
+	m.Inode = statInode(finfo)
+	m.Mode = finfo.Mode()
+}
+
+// hasStat returns true if the file status is recorded.
+func (m *Metadata) hasStat() bool {
+	return m.ModTime != 0
+}
+
+// sameStat returns true if both instances record the same file status.
+func (m *Metadata) sameStat(other *Metadata) bool {
+	return m.hasStat() && m.ModTime == other.ModTime && m.Size == other.Size &&
+		m.Inode == other.Inode && m.Mode == other.Mode && len(other.ContentHash) > 0
+}
+
+// setStatFrom copies the file status from other.
+func (m *Metadata) setStatFrom(other *Metadata) {
+	m.Size, m.ModTime, m.Inode, m.Mode = other.Size, other.ModTime, other.Inode, other.Mode
 }
 
 //HasDoFile returns true if the metadata has a non-empty DoField field.
@@ -48,6 +48,16 @@ func (db *NullDb) GetRecords(prefix string) ([]Record, error) {
 	return []Record{}, nil
 }
 
+// GetAllRecords returns a list of all the records in the database.
+func (db *NullDb) GetAllRecords() ([]Record, error) {
+	return []Record{}, nil
+}
+
+// Write discards the batch.
+func (db *NullDb) Write(batch *Batch) error {
+	return nil
+}
+
 func (db *NullDb) Close() error {
 	return nil
 }
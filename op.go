@@ -6,10 +6,22 @@ package redux
 
 import (
 	"fmt"
+	"os"
 )
 
 // Redo finds and executes the .do file for the given target.
+// The target is locked for the duration.
 func (target *File) Redo() error {
+	lock, err := target.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return target.redo()
+}
+
+func (target *File) redo() error {
 
 	doInfo, err := target.findDoFile()
 	if err != nil {
@@ -20,6 +32,10 @@ func (target *File) Redo() error {
 		target.DoFile = doInfo.Path()
 	}
 
+	if target.HasDoFile() {
+		target.emit(BuildEvent{Kind: EVENT_DO_FILE, DoFile: target.Rel(target.DoFile)})
+	}
+
 	cachedMeta, recordFound, err := target.GetMetadata()
 	if err != nil {
 		return err
@@ -39,6 +55,8 @@ func (target *File) Redo() error {
 				return target.Errorf("Missing .do file")
 			} else if !targetMeta.Equal(&cachedMeta) {
 				return target.redoStatic(IFCHANGE, targetMeta)
+			} else if err := target.refreshStat(targetMeta); err != nil {
+				return err
 			}
 		} else {
 			if target.HasDoFile() {
@@ -87,19 +105,23 @@ func (f *File) redoTarget(doInfo *DoInfo, oldMeta *Metadata) error {
 		panic("nil DoInfo")
 	}
 
-	// Prerequisites will be recreated...
-	// Ideally, this could be done within a transaction to allow for rollback
-	// in the event of failure.
-	if err := f.DeleteAutoPrerequisites(); err != nil {
+	// The target's records are updated in transactions so that a failure,
+	// or the death of the process, does not leave them half replaced.
+	f.Begin()
+	defer f.Rollback()
+
+	// The target is out of date until the do script succeeds, even if the script fails
+	// or is interrupted after its prerequisites have been brought up to date.
+	if err := f.PutMustRebuild(); err != nil {
 		return err
 	}
 
+	// The do file, and the more specific do files that do not exist, are system generated prerequisites.
+	auto := map[Event]map[Hash]Prerequisite{AUTO_IFCREATE: {}, AUTO_IFCHANGE: {}}
+
 	for _, path := range doInfo.Missing {
 		relpath := f.Rel(path)
-		err := f.PutPrerequisite(AUTO_IFCREATE, MakeHash(relpath), Prerequisite{Path: relpath})
-		if err != nil {
-			return err
-		}
+		auto[AUTO_IFCREATE][MakeHash(relpath)] = Prerequisite{Path: relpath}
 	}
 
 	doFile, err := NewFile(doInfo.Dir, doInfo.Name)
@@ -119,7 +141,26 @@ func (f *File) redoTarget(doInfo *DoInfo, oldMeta *Metadata) error {
 	}
 
 	relpath := f.Rel(doInfo.Path())
-	if err := f.PutPrerequisite(AUTO_IFCHANGE, MakeHash(relpath), Prerequisite{relpath, doMeta}); err != nil {
+	auto[AUTO_IFCHANGE][MakeHash(relpath)] = Prerequisite{relpath, doMeta}
+
+	if err := f.ReplaceAutoPrerequisites(auto); err != nil {
+		return err
+	}
+
+	// A stamp from a previous run does not apply to this one.
+	if err := f.DeleteStamp(); err != nil {
+		return err
+	}
+
+	// The do script's redo commands list the prerequisites they record,
+	// so that those it no longer declares can be removed afterwards.
+	if err := f.beginDeclarations(); err != nil {
+		return err
+	}
+	defer os.Remove(f.declaredPath())
+
+	// The do script's redo commands record its prerequisites, so the changes must be written first.
+	if err := f.Commit(); err != nil {
 		return err
 	}
 
@@ -127,9 +168,31 @@ func (f *File) redoTarget(doInfo *DoInfo, oldMeta *Metadata) error {
 		return err
 	}
 
+	f.Begin()
+
+	declared, err := f.endDeclarations()
+	if err != nil {
+		return err
+	}
+
+	stale, err := f.deleteUndeclaredPrerequisites(declared)
+	if err != nil {
+		return err
+	}
+
+	// The do script may no longer call redo-always.
+	if err := f.deleteStaleAlways(); err != nil {
+		return err
+	}
+
 	// A task script does not produce output and has no dependencies...
 	if f.IsTask() {
-		return nil
+		if err := f.DeleteMustRebuild(); err != nil {
+			return err
+		} else if err := f.Commit(); err != nil {
+			return err
+		}
+		return f.deleteStaleDependencies(stale)
 	}
 
 	newMeta, err := f.NewMetadata()
@@ -139,14 +202,34 @@ func (f *File) redoTarget(doInfo *DoInfo, oldMeta *Metadata) error {
 		return f.ErrNotFound("redoTarget: f.NewMetadata")
 	}
 
+	// The do script may have called redo-stamp.
+	// If not, a stamp carried over from the previous build no longer applies.
+	stamp, _, err := f.GetStamp()
+	if err != nil {
+		return err
+	}
+	newMeta.Stamp = stamp
+
 	if err := f.PutMetadata(newMeta); err != nil {
 		return err
 	}
 
+	if err := f.DeleteStamp(); err != nil {
+		return err
+	}
+
 	if err := f.DeleteMustRebuild(); err != nil {
 		return err
 	}
 
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	if err := f.deleteStaleDependencies(stale); err != nil {
+		return err
+	}
+
 	// Notify dependents if a content change has occurred.
 	return f.GenerateNotifications(oldMeta, newMeta)
 }
@@ -180,7 +263,14 @@ func (f *File) redoStatic(event Event, oldMeta *Metadata) error {
 
 // RedoIfChange runs redo on the target if it is out of date or its current state
 // disagrees with its dependent's version of its state.
+// The target is locked for the duration so that, if another process is building it,
+// RedoIfChange waits for the build to complete and finds the target up to date.
 func (target *File) RedoIfChange(dependent *File) error {
+	lock, err := target.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
 
 	recordRelation := func(m *Metadata) error {
 		return RecordRelation(dependent, target, IFCHANGE, m)
@@ -197,12 +287,16 @@ func (target *File) RedoIfChange(dependent *File) error {
 		goto REDO
 	}
 
-	if isCurrent, err := target.IsCurrent(); err != nil {
+	if isCurrent, err := target.isCurrentAfterAlways(); err != nil {
 		return err
 	} else if !isCurrent {
 		goto REDO
 	} else {
 
+		if err := target.refreshStat(targetMeta); err != nil {
+			return err
+		}
+
 		// Compare dependent's version of the target's state to its current state.
 		// Target is self consistent, but may have changed since the prerequisite record was created.
 		prereq, found, err := dependent.GetPrerequisite(IFCHANGE, target.PathHash)
@@ -211,18 +305,26 @@ func (target *File) RedoIfChange(dependent *File) error {
 		} else if !found {
 			// There is no record of the dependency so this is the first time through.
 			// Since the target is up to date, use its metadata for the dependency.
+			target.emit(BuildEvent{Kind: EVENT_CURRENT})
 			return recordRelation(targetMeta)
 		}
 
 		if prereq.Equal(targetMeta) {
 			// target is up to date and its current state agrees with dependent's version.
-			// Nothing to do here.
-			return nil
+			// The relation is already recorded, so it only needs to be declared.
+			target.emit(BuildEvent{Kind: EVENT_CURRENT})
+			return declare(dependent.makeKey(REQUIRES, IFCHANGE, target.PathHash))
 		}
+
+		// target is up to date, but has changed since the dependent recorded it.
+		// Rebuilding it would not change it, and would rebuild a target that must
+		// always be rebuilt more than once per run, so update the dependent's version.
+		target.emit(BuildEvent{Kind: EVENT_CURRENT})
+		return recordRelation(targetMeta)
 	}
 
 REDO:
-	err = target.Redo()
+	err = target.redo()
 	if err != nil {
 		return err
 	}
@@ -5,7 +5,10 @@
 package redux
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Options default to env values, to be overriden by main() if necessary.
@@ -13,6 +16,29 @@ var (
 	Verbosity = len(os.Getenv("REDO_VERBOSE"))
 	Debug     = len(os.Getenv("REDO_DEBUG")) > 0
 	ShellArgs = os.Getenv("REDO_SHELL_ARGS")
+	Jobs      = envInt("REDO_JOBS", 1)
+	RunID     = os.Getenv("REDO_RUN_ID") // identifies the top level redo invocation.
+	KeepGoing = len(os.Getenv("REDO_KEEP_GOING")) > 0
 )
 
 func Verbose() bool { return Verbosity > 0 }
+
+// SetOptions sets the process wide options from the configuration of the project being built.
+func SetOptions(c Config) {
+	Verbosity = c.Verbosity
+	Jobs = c.Jobs
+}
+
+// envInt returns the integer value of the named environment variable
+// or the default value if the variable is unset or invalid.
+func envInt(name string, value int) int {
+	if i64, err := strconv.ParseInt(os.Getenv(name), 10, 32); err == nil {
+		return int(i64)
+	}
+	return value
+}
+
+// NewRunID returns a new, unique, RunID.
+func NewRunID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), os.Getpid())
+}
@@ -0,0 +1,56 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bytes"
+)
+
+// An Outdated explains why a file is out of date.
+// When the file is out of date because one of its prerequisites is,
+// Cause explains why that prerequisite is out of date, and so on down the tree.
+type Outdated struct {
+	Path   string    `json:"path"`
+	Reason string    `json:"reason"`
+	Cause  *Outdated `json:"cause,omitempty"`
+}
+
+// Reasons for being out of date.
+const (
+	OUTDATED_REBUILD      = "is flagged for rebuild"
+	OUTDATED_ALWAYS       = "is always rebuilt"
+	OUTDATED_NO_RECORD    = "has no database record"
+	OUTDATED_MISSING      = "does not exist"
+	OUTDATED_CONTENT      = "content hash differs"
+	OUTDATED_CREATED      = "was created"
+	OUTDATED_CHANGED      = "changed"
+	OUTDATED_PREREQUISITE = "has an out of date prerequisite"
+)
+
+// String returns the explanation as a chain, starting with the file and
+// ending with the root cause. For example:
+//
+//	app <- lib.o <- lib.c content hash differs
+func (o *Outdated) String() string {
+	var buf bytes.Buffer
+	for ; o != nil; o = o.Cause {
+		if buf.Len() > 0 {
+			buf.WriteString(" <- ")
+		}
+		buf.WriteString(o.Path)
+		if o.Cause == nil {
+			buf.WriteString(" " + o.Reason)
+		}
+	}
+	return buf.String()
+}
+
+// Leaf returns the root cause.
+func (o *Outdated) Leaf() *Outdated {
+	for o != nil && o.Cause != nil {
+		o = o.Cause
+	}
+	return o
+}
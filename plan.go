@@ -0,0 +1,80 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+// OUTDATED_REQUESTED explains why a current target is rebuilt by redo.
+const OUTDATED_REQUESTED = "is requested"
+
+// A Step is a target whose do script would run.
+type Step struct {
+	*Outdated
+	DoFile string // full path to the do script.
+}
+
+// Plan returns the steps that redo would take to build the targets, in build order,
+// along with the reason for each. The targets themselves are always included, since redo
+// rebuilds them even if they are current. Their prerequisites are included if they are out of date,
+// as they would be with redo-ifchange.
+//
+// Plan does not run do scripts or change the database, so it can only follow the prerequisites
+// recorded by earlier builds. A do script that runs may well require other files.
+func Plan(targets ...*File) ([]*Step, error) {
+	var steps []*Step
+	visited := make(map[Hash]bool)
+	for _, target := range targets {
+		if err := target.plan(true, visited, &steps); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+func (f *File) plan(force bool, visited map[Hash]bool, steps *[]*Step) error {
+
+	if visited[f.FullPathHash] {
+		return nil
+	}
+	visited[f.FullPathHash] = true
+
+	doInfo, err := f.findDoFile()
+	if err != nil {
+		return err
+	}
+
+	// Source files are not built.
+	if doInfo.Name == "" {
+		return nil
+	}
+
+	f.DoFile = doInfo.Path()
+
+	outdated, err := f.Outdated()
+	if err != nil {
+		return err
+	}
+
+	if outdated == nil {
+		if !force {
+			return nil
+		}
+		outdated = &Outdated{Path: f.Path, Reason: OUTDATED_REQUESTED}
+	}
+
+	// The do script would call redo-ifchange on its prerequisites before it completes.
+	prerequisites, err := f.PrerequisiteFiles(IFCHANGE, AUTO_IFCHANGE)
+	if err != nil {
+		return err
+	}
+
+	for _, prerequisite := range prerequisites {
+		if err := prerequisite.plan(false, visited, steps); err != nil {
+			return err
+		}
+	}
+
+	*steps = append(*steps, &Step{Outdated: outdated, DoFile: f.DoFile})
+
+	return nil
+}
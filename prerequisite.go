@@ -4,6 +4,10 @@
 
 package redux
 
+import (
+	"fmt"
+)
+
 // Prerequisite from a source to a target.
 type Prerequisite struct {
 	Path      string // path back to target of prerequisite.
@@ -11,8 +15,9 @@ type Prerequisite struct {
 }
 
 // PutPrerequisite stores the given prerequisite using a key based on the event and hash.
+// An unchanged record is not rewritten.
 func (f *File) PutPrerequisite(event Event, hash Hash, prereq Prerequisite) error {
-	return f.Put(f.makeKey(REQUIRES, event, hash), prereq)
+	return f.putIfChanged(f.makeKey(REQUIRES, event, hash), prereq)
 }
 
 // GetPrerequisite returns the prerequisite for the event and hash.
@@ -38,7 +43,7 @@ func prefixed(f *File, prefix string) ([]*record, error) {
 
 	for i, row := range rows {
 		if decoded, err := decodePrerequisite(row.Value); err != nil {
-			return nil, err
+			return nil, fmt.Errorf("cannot decode prerequisite record %s: %s. Try redux fsck", row.Key, err)
 		} else {
 			out[i] = &record{row.Key, &decoded}
 		}
@@ -129,6 +134,37 @@ func destroy(f *File, prefix string) error {
 	})
 }
 
+// ReplaceAutoPrerequisites replaces the file's system generated prerequisites with prereqs,
+// which are keyed by event and hash. Only the records that differ are deleted or written.
+func (f *File) ReplaceAutoPrerequisites(prereqs map[Event]map[Hash]Prerequisite) error {
+	want := make(map[string]bool)
+	for event, hashes := range prereqs {
+		for hash := range hashes {
+			want[f.makeKey(REQUIRES, event, hash)] = true
+		}
+	}
+
+	err := visit(f, f.makeKey(REQUIRES, AUTO), func(rec *record) error {
+		if want[rec.key] {
+			return nil
+		}
+		return f.Delete(rec.key)
+	})
+	if err != nil {
+		return err
+	}
+
+	for event, hashes := range prereqs {
+		for hash, prereq := range hashes {
+			if err := f.PutPrerequisite(event, hash, prereq); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 // DeleteAutoPrerequisites removes all of the file's system generated prerequisites.
 func (f *File) DeleteAutoPrerequisites() error {
 	return destroy(f, f.makeKey(REQUIRES, AUTO))
@@ -139,21 +175,29 @@ func (f *File) DeleteAllPrerequisites() error {
 	return destroy(f, f.makeKey(REQUIRES))
 }
 
-func (p *Prerequisite) IsCurrent(rootDir string) (isCurrent bool, err error) {
+func (p *Prerequisite) IsCurrent(rootDir string) (bool, error) {
+	outdated, err := p.Outdated(rootDir)
+	return outdated == nil && err == nil, err
+}
+
+// Outdated returns an explanation of why the prerequisite is out of date, or nil if it is current.
+// A prerequisite is out of date if it has changed since it was recorded or if its file is out of date.
+func (p *Prerequisite) Outdated(rootDir string) (*Outdated, error) {
 	f, err := p.File(rootDir)
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	m, err := f.NewMetadata()
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	isCurrent = p.Equal(m)
-	if !isCurrent {
-		return
+	if m == nil {
+		return &Outdated{Path: f.Path, Reason: OUTDATED_MISSING}, nil
+	} else if !p.Equal(m) {
+		return &Outdated{Path: f.Path, Reason: OUTDATED_CHANGED}, nil
 	}
 
-	return f.IsCurrent()
+	return f.Outdated()
 }
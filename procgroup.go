@@ -0,0 +1,23 @@
+// +build !windows
+
+package redux
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup arranges for the command to run in a process group of its own,
+// so that it can be signalled along with its descendants.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalGroup sends the signal to the command's process group.
+func signalGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+}
@@ -0,0 +1,17 @@
+// +build windows
+
+package redux
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Process groups are not supported on windows, so only the command itself is signalled.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// Windows cannot deliver signals other than kill.
+func signalGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Kill()
+}
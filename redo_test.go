@@ -6,16 +6,19 @@ package redux
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 type Dir struct {
@@ -707,6 +710,72 @@ func TestSharedPrerequisiteChange(t *testing.T) {
 	}
 }
 
+// A prerequisite that is current, but has changed since its dependent recorded it,
+// is not rebuilt when the dependent is. The dependent records its new state instead.
+func TestChangedCurrentPrerequisite(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	app := Script{Name: "app", Command: "redo-ifchange lib; cat lib"}
+	lib := Script{Name: "lib", Command: "printf x >> runs; cat runs"}
+
+	for _, target := range []Script{app, lib, app} {
+		if result := dir.Run(target, app, lib); result.Err != nil {
+			t.Fatal(result)
+		}
+	}
+
+	// lib was built by the first two runs, but not by the last.
+	CheckFileContent(t, dir.Append("runs"), "xx")
+	CheckFileContent(t, dir.Append("app"), "xx")
+}
+
+// The status of a source file that was touched, but not changed, is recorded
+// when redo-ifchange next requires it, rather than when it is inspected.
+func TestRefreshStatus(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.WriteFile("src", "src"); err != nil {
+		t.Fatal(err)
+	}
+
+	app := Script{Name: "app", Command: "redo-ifchange src; cat src"}
+	all := Script{Name: "@all", Command: "redo-ifchange app src"}
+
+	if result := dir.Run(app); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(dir.Append("src"), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := dir.Run(all, app); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	src, err := NewFile(dir.path, "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m, found, err := src.GetMetadata(); err != nil {
+		t.Fatal(err)
+	} else if !found {
+		t.Fatal("want metadata for src")
+	} else if m.ModTime != past.UnixNano() {
+		t.Errorf("want recorded modification time %d, got %d", past.UnixNano(), m.ModTime)
+	}
+}
+
 // Loop detection
 func TestDetectLoop(t *testing.T) {
 
@@ -775,3 +844,1495 @@ func TestArg2(t *testing.T) {
 		dir.Cleanup()
 	}
 }
+
+// A dependent is not rebuilt when its prerequisite is rebuilt with different content
+// but the same stamp.
+func TestStamp(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	all := Script{Name: "@all", Command: "redo-ifchange app"}
+
+	app := Script{Name: "app"}
+	app.Command = `
+redo-ifchange version
+value=1
+if test -e $1 ; then
+value=$(expr $(cat $1) + 1)
+fi
+printf "%d" $value
+`
+	version := Script{Name: "version"}
+	version.Command = `
+redo-ifchange source
+(cat source; date +%s%N) > $3
+redo-stamp < source
+`
+
+	steps := []struct {
+		source string
+		count  string
+	}{
+		{"v1", "1"},
+		{"v1", "1"}, // stamp is unchanged
+		{"v2", "2"},
+	}
+
+	for _, step := range steps {
+		if err := dir.WriteFile("source", step.source); err != nil {
+			t.Fatal(err)
+		}
+
+		if result := dir.Run(version, app); result.Err != nil {
+			t.Fatal(result)
+		}
+
+		if result := dir.Run(all, app, version); result.Err != nil {
+			t.Fatal(result)
+		}
+
+		CheckFileContent(t, dir.Append("app"), step.count)
+	}
+}
+
+// A target that calls redo-always is rebuilt once per run,
+// and, with a stamp, only rebuilds its dependents when the stamp changes.
+func TestAlways(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	all := Script{Name: "@all", Command: "redo-ifchange version app"}
+
+	app := Script{Name: "app"}
+	app.Command = `
+redo-ifchange version
+value=1
+if test -e $1 ; then
+value=$(expr $(cat $1) + 1)
+fi
+printf "%d" $value
+`
+	version := Script{Name: "version"}
+	version.Command = `
+redo-always
+printf x >> runs
+(cat source; date +%s%N) > $3
+redo-stamp < source
+`
+
+	steps := []struct {
+		source string
+		runs   string
+		count  string
+	}{
+		{"v1", "x", "1"},
+		{"v1", "xx", "1"},
+		{"v2", "xxx", "2"},
+	}
+
+	for _, step := range steps {
+		if err := dir.WriteFile("source", step.source); err != nil {
+			t.Fatal(err)
+		}
+
+		if result := dir.Run(all, app, version); result.Err != nil {
+			t.Fatal(result)
+		}
+
+		CheckFileContent(t, dir.Append("runs"), step.runs)
+		CheckFileContent(t, dir.Append("app"), step.count)
+	}
+}
+
+// The listing commands report targets, sources and out of date targets.
+func TestListings(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	sorted := Scripts.Get("sorted-list")
+	list := Scripts.Get("list")
+
+	if result := dir.Run(sorted, list); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	// outdates list and, through it, sorted-list.
+	if err := dir.WriteFile(list.OutputFileName(), "Break checksum and timestamp!"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"redo-targets"}, "list\nsorted-list\n"},
+		{[]string{"redo-sources"}, "list.do\nsorted-list.do\n"},
+		{[]string{"redo-ood", "-0"}, "list\x00sorted-list\x00"},
+	}
+
+	for _, test := range tests {
+		cmd := exec.Command(test.args[0], test.args[1:]...)
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err != nil {
+			t.Fatal(result)
+		}
+		if result.Stdout != test.want {
+			t.Errorf("%s: want %q, got %q", strings.Join(test.args, " "), test.want, result.Stdout)
+		}
+	}
+}
+
+// redo-whichdo lists the do file candidates in search order and fails when none exists.
+func TestWhichDo(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("redo-whichdo", "file.txt")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err == nil {
+		t.Errorf("Expected redo-whichdo to fail without a do file: %s", result)
+	}
+
+	if err := dir.WriteFile("default.txt.do", "echo -n text"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = exec.Command("redo-whichdo", "file.txt")
+	cmd.Dir = dir.path
+	result := run(t, cmd)
+	if result.Err != nil {
+		t.Fatal(result)
+	}
+
+	want := "missing  file.txt.do\t$1=file.txt\t$2=file.txt\treldir=.\n" +
+		"selected default.txt.do\t$1=file.txt\t$2=file\treldir=.\n" +
+		"missing  default.do\t$1=file.txt\t$2=file.txt\treldir=.\n"
+
+	if result.Stdout != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, result.Stdout)
+	}
+}
+
+// redux explain follows the out of date prerequisites down to the root cause.
+func TestExplain(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	sorted := Scripts.Get("sorted-list")
+	list := Scripts.Get("list")
+
+	if result := dir.Run(sorted, list); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	explain := func(args ...string) string {
+		cmd := exec.Command("redux", append([]string{"explain"}, args...)...)
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err != nil {
+			t.Fatal(result)
+		}
+		return result.Stdout
+	}
+
+	if got, want := explain("sorted-list"), "sorted-list is current\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// Inspecting the files does not change the database, even to record the status of a touched file.
+	records := func() map[string]string {
+		out := make(map[string]string)
+		err := filepath.Walk(dir.Append(REDO_DIR, DATA_DIR), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				b, err := ioutil.ReadFile(path)
+				out[path] = string(b)
+				return err
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	before := records()
+
+	// The status of a file modified within the last two seconds is not recorded.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(dir.Append("list"), past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := explain("sorted-list"), "sorted-list is current\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	cmd := exec.Command("redux", "ood")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	if after := records(); !reflect.DeepEqual(before, after) {
+		t.Errorf("want the database to be left alone")
+	}
+
+	if err := dir.WriteFile(list.OutputFileName(), "Break checksum and timestamp!"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := explain("sorted-list"), "sorted-list <- list changed\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	want := `{"path":"sorted-list","current":false,"reason":"has an out of date prerequisite",` +
+		`"cause":{"path":"list","reason":"changed"}}` + "\n"
+	if got := explain("-json", "sorted-list"); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if result := dir.Run(sorted); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	if err := dir.WriteFile("list.do", "echo changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := explain("sorted-list"), "sorted-list <- list <- list.do changed\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+// redux graph prints the recorded dependencies in each supported format.
+func TestGraph(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	sorted := Scripts.Get("sorted-list")
+	list := Scripts.Get("list")
+
+	if result := dir.Run(sorted, list); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	graph := func(args ...string) string {
+		cmd := exec.Command("redux", append([]string{"graph"}, args...)...)
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err != nil {
+			t.Fatal(result)
+		}
+		return result.Stdout
+	}
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"sorted-list"}, []string{
+			`"sorted-list" [fillcolor="#add8e6", tooltip="target"];`,
+			`"list.do" [fillcolor="#eeeeee", tooltip="source"];`,
+			`"sorted-list" -> "list" [label="ifchange"];`,
+			`"sorted-list" -> "sorted-list.do" [label="auto/ifchange"];`,
+		}},
+		{[]string{"-format", "json", "list"}, []string{
+			`"path": "list",`,
+			`"event": "auto/ifchange"`,
+		}},
+		{[]string{"-format", "mermaid"}, []string{
+			`-->|"ifchange"|`,
+			`:::target`,
+		}},
+	}
+
+	for _, test := range tests {
+		got := graph(test.args...)
+		for _, want := range test.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("graph %s: missing %q in:\n%s", strings.Join(test.args, " "), want, got)
+			}
+		}
+	}
+
+	if got := graph("list"); strings.Contains(got, "sorted-list") {
+		t.Errorf("graph list: unexpected dependent in:\n%s", got)
+	}
+}
+
+// The configuration file written by redo-init controls how do scripts are run
+// and environment variables override it.
+func TestConfig(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(dir.Append(".redo", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config["db"] != "file" || config["shell"] != "/bin/sh" {
+		t.Errorf("unexpected default config: %s", b)
+	}
+
+	config["env"] = []string{"PATH", "KEEP_*"}
+	config["tmp_dir"] = "scratch"
+
+	if b, err = json.Marshal(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile(".redo/config", string(b)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile("@env.do", `echo "keep=$KEEP_ME drop=$DROP_ME"`); err != nil {
+		t.Fatal(err)
+	}
+
+	redo := func(env ...string) Result {
+		cmd := exec.Command("redo", "@env")
+		cmd.Dir = dir.path
+		cmd.Env = append(os.Environ(), env...)
+		return run(t, cmd)
+	}
+
+	result := redo("KEEP_ME=yes", "DROP_ME=yes")
+	if result.Err != nil {
+		t.Fatal(result)
+	}
+
+	if want := "keep=yes drop=\n"; result.Stdout != want {
+		t.Errorf("want %q, got %q", want, result.Stdout)
+	}
+
+	if _, err := os.Stat(dir.Append(".redo", "scratch")); err != nil {
+		t.Errorf("expected configured temp directory to exist: %s", err)
+	}
+
+	if result := redo("REDO_SHELL=/nonexistent/sh"); result.Err == nil {
+		t.Errorf("expected REDO_SHELL to override the configured shell: %s", result)
+	}
+}
+
+// A file whose size, modification time, inode and mode are unchanged is not hashed again,
+// so a change that preserves all of them goes unnoticed.
+func TestStatPrecheck(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile("out.do", "redo-ifchange src\ncat src\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile("@top.do", "redo-ifchange out\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files modified very recently are always hashed, so age the source.
+	writeSource := func(content string, mtime time.Time) {
+		if err := dir.WriteFile("src", content); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(dir.Append("src"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	build := func(want string) {
+		cmd := exec.Command("redo", "@top")
+		cmd.Dir = dir.path
+		if result := run(t, cmd); result.Err != nil {
+			t.Fatal(result)
+		}
+
+		b, err := ioutil.ReadFile(dir.Append("out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+
+	past := time.Now().Add(-time.Hour)
+
+	writeSource("aaaa", past)
+	build("aaaa")
+
+	writeSource("bbbb", past)
+	build("aaaa")
+
+	writeSource("bbbb", past.Add(time.Second))
+	build("bbbb")
+}
+
+// Content hashes computed with an algorithm other than the default are prefixed with its name.
+func TestHashAlgorithm(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile(".redo/config", `{"hash": "sha256"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile("out.do", "echo -n hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("redo", "out")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	f, err := NewFile(dir.path, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, found, err := f.GetMetadata()
+	if err != nil {
+		t.Fatal(err)
+	} else if !found {
+		t.Fatal("missing metadata for out")
+	}
+
+	want := Hash("sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	if m.ContentHash != want {
+		t.Errorf("want %s, got %s", want, m.ContentHash)
+	}
+
+	if isCurrent, err := f.IsCurrent(); err != nil {
+		t.Fatal(err)
+	} else if !isCurrent {
+		t.Errorf("expected out to be current")
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"all.do":   "redo-ifchange bad good",
+		"bad.do":   "echo failing >&2; exit 3",
+		"good.do":  "echo good",
+		"other.do": "echo other",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "-k", "all", "other")
+	cmd.Dir = dir.path
+	result := run(t, cmd)
+	if result.Err == nil {
+		t.Fatalf("expected redo -k to fail: %s", result)
+	}
+
+	for _, name := range []string{"good", "other"} {
+		CheckFileContent(t, dir.Append(name), name+"\n")
+	}
+
+	CheckMatch(t, `2 targets failed:`, result.Stderr)
+	CheckMatch(t, `bad \(bad\.do\): .*exit status 3`, result.Stderr)
+	CheckMatch(t, `all \(all\.do\): `, result.Stderr)
+
+	if _, err := os.Stat(dir.Append("bad")); !os.IsNotExist(err) {
+		t.Errorf("expected bad not to exist. Error: %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"app.do":       "redo-ifchange lib.o main.o; cat lib.o main.o",
+		"default.o.do": "redo-ifchange $2.c; cat $2.c",
+		"lib.c":        "lib",
+		"main.c":       "main",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dryRun := func() string {
+		cmd := exec.Command("redo", "-n", "app")
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err != nil {
+			t.Fatal(result)
+		}
+		return result.Stdout
+	}
+
+	CheckMatch(t, `(?m)^app\.do: app has no database record$`, dryRun())
+
+	if _, err := os.Stat(dir.Append("app")); !os.IsNotExist(err) {
+		t.Fatalf("expected app not to be built. Error: %v", err)
+	}
+
+	cmd := exec.Command("redo", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	if err := dir.WriteFile("lib.c", "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	var before []Record
+	err = WithDB(dir.path, func(db DB) (err error) {
+		before, err = db.GetAllRecords()
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "default.o.do: lib.o <- lib.c changed\napp.do: app <- lib.o <- lib.c changed\n# "
+	if out := dryRun(); !strings.HasPrefix(out, want) {
+		t.Errorf("want output starting with %q, got %q", want, out)
+	}
+
+	var after []Record
+	err = WithDB(dir.path, func(db DB) (err error) {
+		after, err = db.GetAllRecords()
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected dry run not to change the database")
+	}
+
+	CheckFileContent(t, dir.Append("lib.o"), "lib")
+}
+
+func TestBuildLog(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"app.do":       "echo building app >&2; redo-ifchange lib.o; cat lib.o",
+		"default.o.do": "echo compiling $2 >&2; echo $2",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "app")
+	cmd.Dir = dir.path
+	result := run(t, cmd)
+	if result.Err != nil {
+		t.Fatal(result)
+	}
+
+	// The output is still shown as it is written.
+	if want := "building app\ncompiling lib\n"; result.Stderr != want {
+		t.Errorf("want stderr %q, got %q", want, result.Stderr)
+	}
+
+	log := func(args ...string) string {
+		cmd := exec.Command("redux", append([]string{"log"}, args...)...)
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err != nil {
+			t.Fatal(result)
+		}
+		return result.Stdout
+	}
+
+	if got, want := log("lib.o"), "compiling lib\n"; got != want {
+		t.Errorf("want log %q, got %q", want, got)
+	}
+
+	// The output of lib.o is in the log of app, so only its heading is added.
+	CheckMatch(t, `(?s)^==> lib\.o .*<==\n==> app .*<==\nbuilding app\ncompiling lib\n$`, log("-r", "app"))
+
+	// A prerequisite that was not built in the same run is not included.
+	cmd = exec.Command("redo", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	CheckMatch(t, `(?s)^==> app .*<==\nbuilding app\n$`, log("-r", "app"))
+
+	// The output of a prerequisite that was built by a sibling is not in the log of b, so it is printed.
+	for name, content := range map[string]string{
+		"a.do": "redo-ifchange shared.o; cat shared.o",
+		"b.do": "echo building b >&2; redo-ifchange shared.o; cat shared.o",
+	} {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd = exec.Command("redo", "a", "b")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	CheckMatch(t, `(?s)^==> shared\.o .*<==\ncompiling shared\n==> b .*<==\nbuilding b\n$`, log("-r", "b"))
+}
+
+// A background process that keeps the do script's standard error open does not hold up the build.
+func TestBuildLogBackground(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile("app.do", "echo building app >&2\nsleep 5 >/dev/null &\necho app"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	cmd := exec.Command("redo", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("want redo to return once the do script exits, took %s", elapsed)
+	}
+
+	cmd = exec.Command("redux", "log", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	} else if want := "building app\n"; result.Stdout != want {
+		t.Errorf("want log %q, got %q", want, result.Stdout)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"app.do":   "redo-ifchange lib.o; cat lib.o",
+		"lib.o.do": "redo-ifchange lib.c; cat lib.c",
+		"lib.c":    "lib",
+		"bad.do":   "exit 3",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "-events", "events.json", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	// The environment variable works as well as the option.
+	cmd = exec.Command("redo", "bad")
+	cmd.Dir = dir.path
+	cmd.Env = append(os.Environ(), "REDO_EVENTS=events.json")
+	if result := run(t, cmd); result.Err == nil {
+		t.Fatalf("expected bad to fail: %s", result)
+	}
+
+	b, err := ioutil.ReadFile(dir.Append("events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []BuildEvent
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var event BuildEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("%s: %s", line, err)
+		}
+		events = append(events, event)
+	}
+
+	find := func(kind, target string) *BuildEvent {
+		for i := range events {
+			if events[i].Kind == kind && events[i].Target == target {
+				return &events[i]
+			}
+		}
+		t.Errorf("missing %s event for %s", kind, target)
+		return nil
+	}
+
+	find(EVENT_DO_FILE, "app")
+	find(EVENT_START, "app")
+
+	// Events from the nested redo-ifchange process.
+	if event := find(EVENT_RELATION, "lib.o"); event != nil && event.Prerequisite != "lib.c" {
+		t.Errorf("want prerequisite lib.c, got %s", event.Prerequisite)
+	}
+
+	if event := find(EVENT_FINISH, "lib.o"); event != nil {
+		if event.Status == nil || *event.Status != 0 {
+			t.Errorf("want status 0, got %v", event.Status)
+		}
+		if event.DoFile != "lib.o.do" {
+			t.Errorf("want do file lib.o.do, got %s", event.DoFile)
+		}
+	}
+
+	if event := find(EVENT_FINISH, "bad"); event != nil {
+		if event.Status == nil || *event.Status != 3 {
+			t.Errorf("want status 3, got %v", event.Status)
+		}
+		if event.Error == "" {
+			t.Errorf("expected an error for bad")
+		}
+	}
+
+	for _, event := range events {
+		if event.RunID == "" {
+			t.Errorf("missing run id: %+v", event)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"app.do":   "redo-ifchange lib.o; cat lib.o",
+		"lib.o.do": "echo lib",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "-trace", "trace.json", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	b, err := ioutil.ReadFile(dir.Append("trace.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trace struct {
+		TraceEvents []struct {
+			Name string
+			Ph   string
+			Ts   int64
+			Dur  int64
+			Pid  int
+			Args map[string]interface{}
+		}
+	}
+
+	if err := json.Unmarshal(b, &trace); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]int)
+	for i, event := range trace.TraceEvents {
+		if event.Ph == "X" {
+			spans[event.Name] = i
+		}
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("want spans for app and lib.o, got %v", spans)
+	}
+
+	app, lib := trace.TraceEvents[spans["app"]], trace.TraceEvents[spans["lib.o"]]
+
+	if lib.Ts < app.Ts || lib.Ts+lib.Dur > app.Ts+app.Dur {
+		t.Errorf("expected lib.o to be built while app is built: %+v, %+v", app, lib)
+	}
+
+	if lib.Pid == app.Pid {
+		t.Errorf("expected lib.o to be built by a nested process")
+	}
+
+	if depth := lib.Args["depth"]; depth != float64(1) {
+		t.Errorf("want lib.o depth 1, got %v", depth)
+	}
+}
+
+func TestStats(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"app.do": "echo app",
+		"bad.do": "exit 3",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("redo", "app")
+		cmd.Dir = dir.path
+		if result := run(t, cmd); result.Err != nil {
+			t.Fatal(result)
+		}
+	}
+
+	cmd := exec.Command("redo", "bad")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err == nil {
+		t.Fatalf("expected bad to fail: %s", result)
+	}
+
+	getStats := func(path string) BuildStats {
+		f, err := NewFile(dir.path, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats, found, err := f.GetStats()
+		if err != nil {
+			t.Fatal(err)
+		} else if !found {
+			t.Fatalf("missing stats for %s", path)
+		}
+		return stats
+	}
+
+	if stats := getStats("app"); stats.Builds != 2 || stats.Failures != 0 || len(stats.Recent) != 2 {
+		t.Errorf("want 2 successful builds of app, got %+v", stats)
+	} else if last := stats.Last(); last.DoFile != "app.do" || last.Status != 0 || last.Duration <= 0 {
+		t.Errorf("unexpected last build of app: %+v", last)
+	}
+
+	if stats := getStats("bad"); stats.Builds != 1 || stats.Failures != 1 {
+		t.Errorf("want 1 failed build of bad, got %+v", stats)
+	} else if last := stats.Last(); last.Status != 3 || !last.Failed {
+		t.Errorf("want last build of bad to fail with status 3, got %+v", last)
+	}
+
+	cmd = exec.Command("redux", "stats")
+	cmd.Dir = dir.path
+	result := run(t, cmd)
+	if result.Err != nil {
+		t.Fatal(result)
+	}
+
+	CheckMatch(t, `(?m)^ +2  app$`, result.Stdout)
+	CheckMatch(t, `(?m)  bad \(failed, status 3\)$`, result.Stdout)
+	CheckMatch(t, `(?m)  app\.do \(2 builds\)$`, result.Stdout)
+}
+
+func TestTimeout(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"slow.do": "echo partial; sleep 10 & wait",
+		"fast.do": "echo fast",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkTimeout := func(args ...string) {
+		start := time.Now()
+
+		cmd := exec.Command("redo", args...)
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err == nil {
+			t.Fatalf("expected slow to time out: %s", result)
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("expected redo to stop within 5s, took %s", elapsed)
+		}
+
+		CheckMatch(t, `slow: do script timed out after 200ms`, result.Stderr)
+
+		if _, err := os.Stat(dir.Append("slow")); !os.IsNotExist(err) {
+			t.Errorf("expected slow not to be built. Error: %v", err)
+		}
+
+		entries, err := ioutil.ReadDir(dir.Append(REDO_DIR, "tmp"))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(entries) > 0 {
+			t.Errorf("expected temporary outputs to be removed, found %d", len(entries))
+		}
+	}
+
+	checkTimeout("-timeout", "200ms", "slow")
+
+	// A per-target timeout in the configuration file.
+	if err := dir.WriteFile(".redo/config", `{"timeouts": {"slow*": "200ms"}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTimeout("slow")
+
+	cmd := exec.Command("redo", "fast")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"app.do":  "redo-ifchange slow; cat slow",
+		"slow.do": "echo partial; touch started; sleep 30",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "app")
+	cmd.Dir = dir.path
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; ; i++ {
+		if _, err := os.Stat(dir.Append("started")); err == nil {
+			break
+		} else if i == 100 {
+			cmd.Process.Kill()
+			t.Fatal("slow.do did not start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	start := time.Now()
+
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("expected interrupted redo to fail")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected redo to stop within 5s, took %s", elapsed)
+	}
+
+	entries, err := ioutil.ReadDir(dir.Append(REDO_DIR, "tmp"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) > 0 {
+		t.Errorf("expected temporary outputs to be removed, found %d", len(entries))
+	}
+
+	for _, path := range []string{"app", "slow"} {
+		if _, err := os.Stat(dir.Append(path)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be built. Error: %v", path, err)
+		}
+
+		f, err := NewFile(dir.path, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if outdated, err := f.Outdated(); err != nil {
+			t.Fatal(err)
+		} else if outdated == nil || outdated.Reason != OUTDATED_REBUILD {
+			t.Errorf("expected %s to be flagged for rebuild, got %v", path, outdated)
+		}
+	}
+}
+
+func TestUndeclaredPrerequisites(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a":      "a",
+		"b":      "b",
+		"app.do": "redo-ifchange a b\ncat a b",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newFile := func(path string) *File {
+		f, err := NewFile(dir.path, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	app, a, b := newFile("app"), newFile("a"), newFile("b")
+
+	redo := func() {
+		cmd := exec.Command("redo", "app")
+		cmd.Dir = dir.path
+		if result := run(t, cmd); result.Err != nil {
+			t.Fatal(result)
+		}
+	}
+
+	checkRelations := func(want ...*File) {
+		prereqs, err := app.PrerequisiteFiles(IFCHANGE)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(map[string]bool)
+		for _, f := range prereqs {
+			got[f.Path] = true
+		}
+
+		for _, f := range []*File{a, b} {
+			wanted := false
+			for _, w := range want {
+				wanted = wanted || w == f
+			}
+
+			if got[f.Path] != wanted {
+				t.Errorf("want %s to be a prerequisite of app: %t, got %t", f.Path, wanted, got[f.Path])
+			}
+
+			dependents, err := f.EventDependents(IFCHANGE)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if wanted && len(dependents) != 1 {
+				t.Errorf("want app to be the dependent of %s, got %d dependents", f.Path, len(dependents))
+			} else if !wanted && len(dependents) != 0 {
+				t.Errorf("want %s to have no dependents, got %d", f.Path, len(dependents))
+			}
+		}
+	}
+
+	redo()
+	checkRelations(a, b)
+
+	// The record of the prerequisite that is still declared is not rewritten.
+	record := filepath.Join(dir.path, REDO_DIR, DATA_DIR, app.makeKey(REQUIRES, IFCHANGE, a.PathHash))
+	before, err := os.Stat(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dir.WriteFile("app.do", "redo-ifchange a\ncat a"); err != nil {
+		t.Fatal(err)
+	}
+
+	redo()
+	checkRelations(a)
+
+	if after, err := os.Stat(record); err != nil {
+		t.Fatal(err)
+	} else if !os.SameFile(before, after) {
+		t.Errorf("want unchanged prerequisite record for a to be kept as is")
+	}
+}
+
+func TestGC(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(dir.Append("sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a":        "a",
+		"b":        "b",
+		"sub/c":    "c",
+		"app.do":   "redo-ifchange a b sub/c\nredo-ifcreate d\ncat a b sub/c",
+		"other.do": "redo-ifchange a\ncat a",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "app", "other")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	newFile := func(path string) *File {
+		f, err := NewFile(dir.path, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	app, other, b, d := newFile("app"), newFile("other"), newFile("b"), newFile("d")
+
+	// A record left behind by an older version, which app does not match.
+	if err := other.PutDependency(IFCHANGE, app.PathHash, Dependent{Path: "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpFile := dir.Append(REDO_DIR, "tmp", "app-redo-tmp-1")
+	if err := dir.WriteFile(filepath.Join(REDO_DIR, "tmp", "app-redo-tmp-1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(tmpFile, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"b", "sub"} {
+		if err := os.RemoveAll(dir.Append(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gc := func(args ...string) string {
+		cmd := exec.Command("redux", append([]string{"gc"}, args...)...)
+		cmd.Dir = dir.path
+		result := run(t, cmd)
+		if result.Err != nil {
+			t.Fatal(result)
+		}
+		return result.Stdout
+	}
+
+	out := gc("-dry-run")
+
+	CheckMatch(t, `(?m)^stale file: b \(2 records\)$`, out)
+	CheckMatch(t, `(?m)^stale file: sub/c \(2 records\)$`, out)
+	CheckMatch(t, `(?m)^dangling record: app requires b \(ifchange\)$`, out)
+	CheckMatch(t, `(?m)^dangling record: other satisfies app \(ifchange\)$`, out)
+	CheckMatch(t, `(?m)^rebuild: app$`, out)
+	CheckMatch(t, `(?m)^temporary file: \.redo/tmp/app-redo-tmp-1$`, out)
+
+	if strings.Contains(out, "stale file: d") {
+		t.Errorf("want ifcreate prerequisite d to be kept:\n%s", out)
+	}
+
+	if _, found, err := b.GetMetadata(); err != nil {
+		t.Fatal(err)
+	} else if !found {
+		t.Errorf("want dry run to keep the records of b")
+	}
+
+	gc()
+
+	if _, found, err := b.GetMetadata(); err != nil {
+		t.Fatal(err)
+	} else if found {
+		t.Errorf("want the records of b to be removed")
+	}
+
+	if _, err := os.Stat(dir.Append(REDO_DIR, DATA_DIR, string(b.PathHash))); !os.IsNotExist(err) {
+		t.Errorf("want the data directory of b to be removed, got %v", err)
+	}
+
+	if dependents, err := d.EventDependents(IFCREATE); err != nil {
+		t.Fatal(err)
+	} else if len(dependents) != 1 {
+		t.Errorf("want d to keep its dependent, got %d", len(dependents))
+	}
+
+	if dependents, err := other.EventDependents(IFCHANGE); err != nil {
+		t.Fatal(err)
+	} else if len(dependents) != 0 {
+		t.Errorf("want the dangling dependent of other to be removed, got %d", len(dependents))
+	}
+
+	if !app.MustRebuild() {
+		t.Errorf("want app to be flagged for rebuild")
+	}
+
+	if _, err := os.Stat(tmpFile); !os.IsNotExist(err) {
+		t.Errorf("want %s to be removed, got %v", tmpFile, err)
+	}
+
+	CheckMatch(t, `(?m)^Nothing to remove\.$`, gc())
+
+	// Nothing is removed while a build is in progress.
+	lock, err := other.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	if err := os.Remove(dir.Append("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	CheckMatch(t, `(?m)^A build is in progress`, gc("-dry-run"))
+
+	cmd = exec.Command("redux", "gc")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err == nil {
+		t.Errorf("want gc to fail while a build is in progress:\n%s", result)
+	}
+
+	if _, found, err := newFile("a").GetMetadata(); err != nil {
+		t.Fatal(err)
+	} else if !found {
+		t.Errorf("want the records of a to be kept while a build is in progress")
+	}
+}
+
+func TestFsck(t *testing.T) {
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"a":      "a",
+		"b":      "b",
+		"app.do": "redo-ifchange a b\ncat a b",
+	}
+
+	for name, content := range files {
+		if err := dir.WriteFile(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("redo", "app")
+	cmd.Dir = dir.path
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	newFile := func(path string) *File {
+		f, err := NewFile(dir.path, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	app, a, b := newFile("app"), newFile("a"), newFile("b")
+
+	fsck := func(args ...string) Result {
+		cmd := exec.Command("redux", append([]string{"fsck"}, args...)...)
+		cmd.Dir = dir.path
+		return run(t, cmd)
+	}
+
+	CheckMatch(t, `(?m)^No problems found\.$`, fsck().Stdout)
+
+	// A record left half written by a full disk.
+	if err := dir.WriteFile(filepath.Join(REDO_DIR, DATA_DIR, string(a.PathHash), "METADATA"), `{"Path": "a", "Si`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.DeleteDependency(IFCHANGE, app.PathHash); err != nil {
+		t.Fatal(err)
+	}
+
+	result := fsck()
+	if result.Err == nil {
+		t.Fatalf("want fsck to fail on problems:\n%s", result.Stdout)
+	}
+
+	CheckMatch(t, `(?m)^`+string(a.PathHash)+`/METADATA: cannot decode record: .* \[repair: delete record\]$`, result.Stdout)
+	CheckMatch(t, `(?m)^`+string(app.PathHash)+`/requires/ifchange/`+string(b.PathHash)+`: app requires b \(ifchange\), but has no matching dependent record \[repair: write dependent record\]$`, result.Stdout)
+	CheckMatch(t, `2 problems found, 2 repairable with -repair`, result.Stderr)
+
+	if result := fsck("-repair"); result.Err != nil {
+		t.Fatal(result)
+	} else {
+		CheckMatch(t, `(?m)^2 problems repaired\.$`, result.Stdout)
+	}
+
+	if dependents, err := b.EventDependents(IFCHANGE); err != nil {
+		t.Fatal(err)
+	} else if len(dependents) != 1 {
+		t.Errorf("want the dependent record of b to be written, got %d", len(dependents))
+	}
+
+	CheckMatch(t, `(?m)^No problems found\.$`, fsck().Stdout)
+
+	// A cycle cannot be repaired.
+	if err := a.PutPrerequisite(IFCHANGE, app.PathHash, Prerequisite{Path: "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.PutDependency(IFCHANGE, a.PathHash, Dependent{Path: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	result = fsck("-repair")
+	if result.Err == nil {
+		t.Fatalf("want fsck to fail on a cycle:\n%s", result.Stdout)
+	}
+
+	CheckMatch(t, `(?m)^dependency cycle: (a -> app -> a|app -> a -> app)$`, result.Stdout)
+	CheckMatch(t, `1 problems cannot be repaired`, result.Stderr)
+}
@@ -0,0 +1,39 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+)
+
+var cmdAlways = &Command{
+	Run:       runAlways,
+	UsageLine: "redux always",
+	LinkName:  "redo-always",
+	Short:     "Marks the current target as always out of date.",
+	Long: `
+The always command marks the target being built as always out of date.
+It should be run inside a do script.
+
+Such a target is rebuilt once, and only once, in each invocation of redo,
+whether it is named on the command line or reached through redo-ifchange.
+
+It is typically used with redo-stamp so that dependents are only rebuilt
+when the target has actually changed.
+`,
+}
+
+func runAlways(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	target, err := parentFile()
+	if err != nil {
+		return err
+	}
+
+	return target.PutAlways()
+}
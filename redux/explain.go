@@ -0,0 +1,87 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdExplain = &Command{
+	UsageLine: "redux explain [OPTIONS] TARGET...",
+	Short:     "Explains why targets are out of date.",
+	Long: `
+The explain command reports, for each TARGET, whether it is current and, if not, why not.
+The explanation is a chain that starts with the target, follows the out of date prerequisites
+and ends with the root cause. For example:
+
+    app <- lib.o <- lib.c content hash differs
+
+Paths are relative to the redo root directory.
+
+With the -json option, each explanation is printed as a JSON object on a line by itself:
+
+    {"path":"app","current":false,"reason":"has an out of date prerequisite","cause":{...}}
+`,
+}
+
+var explainJSON bool
+
+func init() {
+	// break loop
+	cmdExplain.Run = runExplain
+
+	flg := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flg.BoolVar(&explainJSON, "json", false, "Print explanations as JSON objects.")
+	cmdExplain.Flag = flg
+}
+
+// explanation is the JSON form of an explanation.
+type explanation struct {
+	Path    string `json:"path"`
+	Current bool   `json:"current"`
+	*redux.Outdated
+}
+
+func runExplain(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected one or more TARGET arguments")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		target, err := redux.NewFile(wd, arg)
+		if err != nil {
+			return err
+		}
+
+		outdated, err := target.Outdated()
+		if err != nil {
+			return err
+		}
+
+		if explainJSON {
+			b, err := json.Marshal(explanation{Path: target.Path, Current: outdated == nil, Outdated: outdated})
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+		} else if outdated == nil {
+			fmt.Printf("%s is current\n", target.Path)
+		} else {
+			fmt.Println(outdated)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdFsck = &Command{
+	UsageLine: "redux fsck [OPTIONS]",
+	Short:     "Checks the consistency of the database.",
+	Long: `
+The fsck command checks every record in the database and reports
+
+    records that cannot be decoded, such as those left half written when a disk fills up,
+    records of unknown kinds,
+    metadata records whose path does not match their key,
+    prerequisite records without the matching dependent record and
+    cycles among the ifchange prerequisites.
+
+With the -repair option, fsck also repairs the problems that it can. It deletes records that cannot
+be decoded and metadata with the wrong path, leaving their files out of date so that the records
+are rewritten. It writes missing dependent records. Unknown records and cycles are only reported.
+
+The exit status is non-zero if problems are found and not repaired.
+`,
+}
+
+var fsckRepair bool
+
+func init() {
+	// break loop
+	cmdFsck.Run = runFsck
+
+	flg := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	flg.BoolVar(&fsckRepair, "repair", false, "Repair the problems that can be repaired.")
+	cmdFsck.Flag = flg
+}
+
+func runFsck(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	rootDir, found, err := redux.FindRootDir(wd)
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("cannot find redo root directory for %s", wd)
+	}
+
+	return redux.WithDB(rootDir, func(db redux.DB) error {
+		check, err := redux.CheckDB(db)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range check.Problems {
+			line := p.Description
+			if p.Key != "" {
+				line = p.Key + ": " + line
+			}
+			if p.Repair != "" {
+				line += " [repair: " + p.Repair + "]"
+			}
+			fmt.Println(line)
+		}
+
+		problems := len(check.Problems)
+		if problems == 0 {
+			fmt.Println("No problems found.")
+			return nil
+		}
+
+		if !fsckRepair {
+			return fmt.Errorf("%d problems found, %d repairable with -repair", problems, check.Repairable())
+		}
+
+		if err := check.Repair(db); err != nil {
+			return err
+		}
+
+		repaired := check.Repairable()
+		fmt.Printf("%d problems repaired.\n", repaired)
+
+		if n := problems - repaired; n > 0 {
+			return fmt.Errorf("%d problems cannot be repaired", n)
+		}
+		return nil
+	})
+}
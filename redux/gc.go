@@ -0,0 +1,116 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdGC = &Command{
+	UsageLine: "redux gc [OPTIONS]",
+	Short:     "Removes stale records and files.",
+	Long: `
+The gc command removes the database records and files that no longer serve a purpose:
+
+    the records of files that have been deleted or renamed and have no do file,
+    unless they are ifcreate prerequisites of files that remain,
+    the records of files that remain that refer to such files, or to dependents that no longer require them,
+    the build logs of files that have no records and
+    the temporary files left in the .redo/tmp directory by builds that did not finish.
+
+A target that loses a prerequisite record is flagged for rebuild, so that it is brought up to date without it.
+Since a build changes the records, gc does not remove anything while a build is in progress.
+
+With the -dry-run (or -n) option, gc lists what it would remove without removing anything.
+Paths are relative to the redo root directory.
+`,
+}
+
+var gcDryRun bool
+
+func init() {
+	// break loop
+	cmdGC.Run = runGC
+
+	flg := flag.NewFlagSet("gc", flag.ContinueOnError)
+	flg.BoolVar(&gcDryRun, "dry-run", false, "List what would be removed, without removing it.")
+	flg.BoolVar(&gcDryRun, "n", false, "Alias for dry-run")
+	cmdGC.Flag = flg
+}
+
+func runGC(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	rootDir, found, err := redux.FindRootDir(wd)
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("cannot find redo root directory for %s", wd)
+	}
+
+	return redux.WithDB(rootDir, func(db redux.DB) error {
+		garbage, err := redux.FindGarbage(rootDir, db)
+		if err != nil {
+			return err
+		}
+
+		printGarbage(rootDir, garbage)
+
+		if gcDryRun {
+			return nil
+		}
+
+		return garbage.Collect(db)
+	})
+}
+
+func printGarbage(rootDir string, g *redux.Garbage) {
+	rel := func(path string) string {
+		if s, err := filepath.Rel(rootDir, path); err == nil {
+			return s
+		}
+		return path
+	}
+
+	for _, f := range g.Files {
+		fmt.Printf("stale file: %s (%d records)\n", f.Path, len(f.Keys))
+	}
+
+	for _, rec := range g.Dangling {
+		fmt.Printf("dangling record: %s %s %s (%s)\n", rec.Path, rec.Relation, rec.Other, rec.Event)
+	}
+
+	for _, path := range g.Rebuild {
+		fmt.Printf("rebuild: %s\n", path)
+	}
+
+	for _, path := range g.BuildLogs {
+		fmt.Printf("build log: %s\n", rel(path))
+	}
+
+	for _, path := range g.TempFiles {
+		fmt.Printf("temporary file: %s\n", rel(path))
+	}
+
+	if g.Len() == 0 {
+		fmt.Println("Nothing to remove.")
+	}
+
+	if g.BuildInProgress {
+		fmt.Println("A build is in progress, so nothing can be removed and temporary files are not listed.")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdGraph = &Command{
+	UsageLine: "redux graph [OPTIONS] [TARGET...]",
+	Short:     "Prints the dependency graph.",
+	Long: `
+The graph command prints the dependency graph recorded in the database.
+If TARGET arguments are given, only the part of the graph reachable from them is printed.
+
+Nodes are source files, targets or tasks. Edges point from a file to its prerequisite
+and are labelled with the event that created them: ifchange, ifcreate or, for the
+dependencies that redo records on do files, auto/ifchange and auto/ifcreate.
+Paths are relative to the redo root directory.
+
+The -format option selects the output format:
+
+    dot      Graphviz DOT (default). Render with: redux graph | dot -Tsvg > graph.svg
+    json     A JSON adjacency list.
+    mermaid  A Mermaid flowchart.
+`,
+}
+
+var graphFormat string
+
+func init() {
+	// break loop
+	cmdGraph.Run = runGraph
+
+	flg := flag.NewFlagSet("graph", flag.ContinueOnError)
+	flg.StringVar(&graphFormat, "format", "dot", "Output format: dot, json or mermaid.")
+	cmdGraph.Flag = flg
+}
+
+var graphWriters = map[string]func(io.Writer, *redux.Graph) error{
+	"dot":     writeDot,
+	"json":    writeJSON,
+	"mermaid": writeMermaid,
+}
+
+// node colours by kind.
+var graphColors = map[redux.NodeKind]string{
+	redux.SOURCE: "#eeeeee",
+	redux.TARGET: "#add8e6",
+	redux.TASK:   "#98fb98",
+}
+
+func runGraph(args []string) error {
+	write, ok := graphWriters[graphFormat]
+	if !ok {
+		return fmt.Errorf("unknown graph format %q. Expected dot, json or mermaid", graphFormat)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	rootDir, found, err := redux.FindRootDir(wd)
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("cannot find redo root directory for %s", wd)
+	}
+
+	var paths []string
+	for _, arg := range args {
+		file, err := redux.NewFile(wd, arg)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, file.Path)
+	}
+
+	return redux.WithDB(rootDir, func(db redux.DB) error {
+		graph, err := redux.LoadGraph(db)
+		if err != nil {
+			return err
+		}
+
+		if len(paths) > 0 {
+			graph = graph.Subgraph(paths...)
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		if err := write(out, graph); err != nil {
+			return err
+		}
+		return out.Flush()
+	})
+}
+
+func writeDot(w io.Writer, graph *redux.Graph) error {
+	fmt.Fprintln(w, "digraph redo {")
+	fmt.Fprintln(w, "\tnode [style=filled];")
+
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(w, "\t%s [fillcolor=%q, tooltip=%q];\n", strconv.Quote(node.Path), graphColors[node.Kind], node.Kind)
+	}
+
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "\t%s -> %s [label=%q];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), edge.Event)
+	}
+
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func writeJSON(w io.Writer, graph *redux.Graph) error {
+	type edge struct {
+		Path  string      `json:"path"`
+		Event redux.Event `json:"event"`
+	}
+
+	type node struct {
+		Path     string         `json:"path"`
+		Kind     redux.NodeKind `json:"kind"`
+		Requires []edge         `json:"requires"`
+	}
+
+	nodes := make([]node, len(graph.Nodes))
+	for i, n := range graph.Nodes {
+		nodes[i] = node{Path: n.Path, Kind: n.Kind, Requires: []edge{}}
+		for _, e := range graph.Requires(n.Path) {
+			nodes[i].Requires = append(nodes[i].Requires, edge{Path: e.To, Event: e.Event})
+		}
+	}
+
+	b, err := json.MarshalIndent(struct {
+		Nodes []node `json:"nodes"`
+	}{nodes}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func writeMermaid(w io.Writer, graph *redux.Graph) error {
+	fmt.Fprintln(w, "flowchart LR")
+
+	// Mermaid identifiers cannot contain arbitrary characters, so nodes are numbered.
+	ids := make(map[string]string)
+	for i, node := range graph.Nodes {
+		ids[node.Path] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(w, "\t%s[\"%s\"]:::%s\n", ids[node.Path], mermaidEscape(node.Path), node.Kind)
+	}
+
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(w, "\t%s -->|\"%s\"| %s\n", ids[edge.From], edge.Event, ids[edge.To])
+	}
+
+	for _, kind := range []redux.NodeKind{redux.SOURCE, redux.TARGET, redux.TASK} {
+		fmt.Fprintf(w, "\tclassDef %s fill:%s\n", kind, graphColors[kind])
+	}
+
+	return nil
+}
+
+// mermaidEscape replaces the double quotes that cannot appear in a quoted Mermaid label.
+func mermaidEscape(s string) string {
+	return strings.Replace(s, `"`, "#quot;", -1)
+}
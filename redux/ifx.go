@@ -48,11 +48,27 @@ func runIfChange(args []string) error {
 	})
 }
 
+// parentFile returns the target whose do script is running the current command.
+func parentFile() (*redux.File, error) {
+	parentPath := os.Getenv("REDO_PARENT")
+	if len(parentPath) == 0 {
+		return nil, fmt.Errorf("Missing env variable REDO_PARENT. This program should be run inside a redo script")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	return redux.NewFile(wd, parentPath)
+}
+
 func redoIfX(args []string, fn func(*redux.File, *redux.File) error) error {
 
-	dependentPath := os.Getenv("REDO_PARENT")
-	if len(dependentPath) == 0 {
-		return fmt.Errorf("Missing env variable REDO_PARENT. This program should be run inside a redo script")
+	// The action is triggered by dependent.
+	dependent, err := parentFile()
+	if err != nil {
+		return err
 	}
 
 	wd, err := os.Getwd()
@@ -60,19 +76,18 @@ func redoIfX(args []string, fn func(*redux.File, *redux.File) error) error {
 		return err
 	}
 
-	// The action is triggered by dependent.
-	dependent, err := redux.NewFile(wd, dependentPath)
+	files, err := newFiles(wd, args)
 	if err != nil {
 		return err
 	}
 
-	for _, path := range args {
-		if file, err := redux.NewFile(wd, path); err != nil {
-			return err
-		} else if err := fn(file, dependent); err != nil {
-			return err
-		}
-	}
+	redux.SetOptions(dependent.Config)
 
-	return nil
+	return redux.RunJobs(len(files), func(i int) error {
+		err := fn(files[i], dependent)
+		if err != nil {
+			files[i].RecordFailure(err)
+		}
+		return err
+	})
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -19,10 +20,27 @@ func init() {
 If one or more DIRECTORY arguments are specified, the command initializes each one.
 If no arguments are provided, but an environment variable named %s exists, it is initialized.
 If neither arguments nor an environment variable is provided, the current directory is initialized.
+
+The -db option selects the database used to store dependencies and metadata:
+
+    %s  one file per record under .redo/%s (default).
+    %s   a single, log structured file, .redo/%s, which is faster for large projects.
+
+A directory that is already initialized is converted to the selected database.
+Without the -db option, an existing database is left as it is.
+
+The command writes a default configuration file, .redo/%s, unless one exists.
+See redo-init(1) for its format.
 `
-	cmdInit.Long = fmt.Sprintf(text, redux.REDO_DIR_ENV_NAME)
+	cmdInit.Long = fmt.Sprintf(text, redux.REDO_DIR_ENV_NAME, redux.FILE_DB, redux.DATA_DIR, redux.LOG_DB, redux.LOG_DB_FILE, redux.CONFIG_FILE)
+
+	flg := flag.NewFlagSet("init", flag.ContinueOnError)
+	flg.StringVar(&initDBType, "db", "", "Database type: file or log.")
+	cmdInit.Flag = flg
 }
 
+var initDBType string
+
 func runInit(args []string) error {
 	if len(args) == 0 {
 		if value := os.Getenv(redux.REDO_DIR_ENV_NAME); value != "" {
@@ -35,7 +53,13 @@ func runInit(args []string) error {
 	}
 
 	for _, dir := range args {
-		if err := redux.InitDir(dir); err != nil {
+		var err error
+		if initDBType == "" {
+			err = redux.InitDir(dir)
+		} else {
+			err = redux.InitDirDB(dir, initDBType)
+		}
+		if err != nil {
 			return fmt.Errorf("cannot initialize directory: %s", err)
 		}
 	}
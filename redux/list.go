@@ -0,0 +1,138 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdTargets = &Command{
+	UsageLine: "redux targets [OPTIONS]",
+	LinkName:  "redo-targets",
+	Short:     "Lists the targets in the database.",
+	Long: `
+The targets command lists every file in the database that is built by a do script.
+`,
+}
+
+var cmdSources = &Command{
+	UsageLine: "redux sources [OPTIONS]",
+	LinkName:  "redo-sources",
+	Short:     "Lists the source files in the database.",
+	Long: `
+The sources command lists every file in the database that is not built by a do script.
+These are the static files, including do scripts, whose changes are tracked.
+`,
+}
+
+var cmdOod = &Command{
+	UsageLine: "redux ood [OPTIONS]",
+	LinkName:  "redo-ood",
+	Short:     "Lists the targets that are out of date.",
+	Long: `
+The ood command lists every target in the database that is out of date
+and would be rebuilt by redo-ifchange.
+`,
+}
+
+var listNul bool
+
+func init() {
+	// break loop
+	cmdTargets.Run = runTargets
+	cmdSources.Run = runSources
+	cmdOod.Run = runOod
+
+	for _, cmd := range []*Command{cmdTargets, cmdSources, cmdOod} {
+		cmd.Long += `
+Paths are relative to the current directory and are listed one per line
+or, with the -0 option, separated by NUL characters for use with xargs -0.
+`
+		flg := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+		flg.BoolVar(&listNul, "0", false, "Separate paths with NUL characters rather than newlines.")
+		cmd.Flag = flg
+	}
+}
+
+func runTargets(args []string) error {
+	return listFiles(args, func(rootDir string, m redux.Metadata) (bool, error) {
+		return m.HasDoFile(), nil
+	})
+}
+
+func runSources(args []string) error {
+	return listFiles(args, func(rootDir string, m redux.Metadata) (bool, error) {
+		return !m.HasDoFile(), nil
+	})
+}
+
+func runOod(args []string) error {
+	return listFiles(args, func(rootDir string, m redux.Metadata) (bool, error) {
+		if !m.HasDoFile() {
+			return false, nil
+		}
+
+		file, err := redux.NewFile(rootDir, m.Path)
+		if err != nil {
+			return false, err
+		}
+
+		isCurrent, err := file.IsCurrent()
+		return !isCurrent, err
+	})
+}
+
+// listFiles prints the path of every file in the current project for which the selector returns true.
+func listFiles(args []string, selector func(string, redux.Metadata) (bool, error)) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	rootDir, found, err := redux.FindRootDir(wd)
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("cannot find redo root directory for %s", wd)
+	}
+
+	sep := "\n"
+	if listNul {
+		sep = "\x00"
+	}
+
+	return redux.WithDB(rootDir, func(db redux.DB) error {
+		records, err := redux.AllMetadata(db)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range records {
+			if ok, err := selector(rootDir, m); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+
+			path, err := filepath.Rel(wd, filepath.Join(rootDir, m.Path))
+			if err != nil {
+				return err
+			}
+
+			fmt.Print(path + sep)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdLog = &Command{
+	UsageLine: "redux log [OPTIONS] TARGET...",
+	LinkName:  "redo-log",
+	Short:     "Shows the output of the last build of targets.",
+	Long: `
+The log command prints the standard error output of the do script that last built each TARGET.
+redo saves the output in a build log, in the .redo/log directory, as it shows it.
+
+Since nested redo commands write to the do script's standard error, a target's log includes the output
+of the prerequisites built by its do script. With the -recursive (or -r) option, the log command first prints
+the logs of the prerequisites that were built during the same run, in build order. The output of a prerequisite
+that was built by the target's do script, and is therefore in the target's log, is not repeated.
+`,
+}
+
+var logRecursive bool
+
+func init() {
+	// break loop
+	cmdLog.Run = runLog
+
+	flg := flag.NewFlagSet("log", flag.ContinueOnError)
+	flg.BoolVar(&logRecursive, "recursive", false, "Also print the logs of the prerequisites built in the same run.")
+	flg.BoolVar(&logRecursive, "r", false, "Alias for recursive")
+	cmdLog.Flag = flg
+}
+
+func runLog(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected one or more TARGET arguments")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	headings := logRecursive || len(args) > 1
+
+	for _, arg := range args {
+		target, err := redux.NewFile(wd, arg)
+		if err != nil {
+			return err
+		}
+
+		log, found, err := target.BuildLog()
+		if err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("%s has no build log", target.Path)
+		}
+
+		if logRecursive {
+			shown := []*redux.File{target}
+			if err := printPrerequisiteLogs(target, log.RunID, shown, make(map[redux.Hash]bool)); err != nil {
+				return err
+			}
+		}
+
+		printLog(log, headings)
+	}
+
+	return nil
+}
+
+// printPrerequisiteLogs prints the logs of the target's prerequisites that were built in the given run.
+// A log's output is left out if the build was nested in that of a file whose log is shown, since it is
+// already in that log. Each log is preceded by those of its own prerequisites and sibling logs are printed
+// in the order the builds started.
+func printPrerequisiteLogs(target *redux.File, runID string, shown []*redux.File, visited map[redux.Hash]bool) error {
+	prerequisites, err := target.PrerequisiteFiles(redux.IFCHANGE)
+	if err != nil {
+		return err
+	}
+
+	var built []prerequisiteLog
+
+	for _, prerequisite := range prerequisites {
+		if visited[prerequisite.FullPathHash] {
+			continue
+		}
+		visited[prerequisite.FullPathHash] = true
+
+		log, found, err := prerequisite.BuildLog()
+		if err != nil {
+			return err
+		} else if found && log.RunID == runID {
+			built = append(built, prerequisiteLog{prerequisite, log})
+		}
+	}
+
+	sort.Sort(byStart(built))
+
+	for _, item := range built {
+		output := !nestedInAny(item.log, shown)
+
+		inner := shown
+		if output {
+			inner = append(shown[:len(shown):len(shown)], item.file)
+		}
+
+		if err := printPrerequisiteLogs(item.file, runID, inner, visited); err != nil {
+			return err
+		}
+
+		printHeading(item.log)
+		if output {
+			os.Stdout.Write(item.log.Output)
+		}
+	}
+
+	return nil
+}
+
+func nestedInAny(log *redux.BuildLog, files []*redux.File) bool {
+	for _, f := range files {
+		if log.NestedIn(f) {
+			return true
+		}
+	}
+	return false
+}
+
+type prerequisiteLog struct {
+	file *redux.File
+	log  *redux.BuildLog
+}
+
+type byStart []prerequisiteLog
+
+func (a byStart) Len() int           { return len(a) }
+func (a byStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool { return a[i].log.Start.Before(a[j].log.Start) }
+
+func printLog(log *redux.BuildLog, heading bool) {
+	if heading {
+		printHeading(log)
+	}
+	os.Stdout.Write(log.Output)
+}
+
+func printHeading(log *redux.BuildLog) {
+	fmt.Printf("==> %s (%s) <==\n", log.Path, log.Start.Format("2006-01-02 15:04:05"))
+}
@@ -50,6 +50,18 @@ var commands = []*Command{
 	cmdIfChange,
 	cmdIfCreate,
 	cmdRedo,
+	cmdStamp,
+	cmdAlways,
+	cmdTargets,
+	cmdSources,
+	cmdOod,
+	cmdWhichDo,
+	cmdExplain,
+	cmdGraph,
+	cmdLog,
+	cmdStats,
+	cmdGC,
+	cmdFsck,
 	cmdInstall,
 }
 
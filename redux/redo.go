@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gyepisam/fileutils"
 	"github.com/gyepisam/multiflag"
@@ -29,6 +33,11 @@ If no target argument is provided, redo runs the default do script %s if it exis
 directory.
 
 For compatibility, if %s does not exist, but %s exists, it is used instead.
+
+With the -dry-run (or -n) option, redo prints the do scripts that would run, in build order,
+and why each target is out of date, without running any scripts or changing the database.
+Since dependencies are discovered by running do scripts, a dry run only follows the prerequisites
+recorded by the last build. A do script that runs may require other files, which may need to be built too.
 `
 	it := redux.TASK_PREFIX + redux.DEFAULT_DO
 	cmdRedo.Long = fmt.Sprintf(text, it, it, redux.DEFAULT_DO)
@@ -39,6 +48,12 @@ var (
 	debug     *multiflag.Value
 	isTask    bool
 	shArgs    string
+	jobs      int
+	keepGoing bool
+	planOnly  bool
+	events    string
+	trace     string
+	timeout   time.Duration
 	ignored   bool // like /dev/null for variables
 )
 
@@ -53,6 +68,21 @@ func init() {
 
 	flg.StringVar(&shArgs, "sh", "", "Extra arguments for /bin/sh.")
 
+	flg.IntVar(&jobs, "jobs", 0, "Build up to N targets in parallel.")
+	flg.IntVar(&jobs, "j", 0, "Alias for jobs")
+
+	flg.BoolVar(&keepGoing, "keep-going", false, "Keep building unrelated targets after a failure.")
+	flg.BoolVar(&keepGoing, "k", false, "Alias for keep-going")
+
+	flg.BoolVar(&planOnly, "dry-run", false, "Print the targets that would be built, and why, without building them.")
+	flg.BoolVar(&planOnly, "n", false, "Alias for dry-run")
+
+	flg.StringVar(&events, "events", "", "Append build events, as JSON lines, to FILE.")
+
+	flg.StringVar(&trace, "trace", "", "Write a Chrome trace of the do scripts that run to FILE.")
+
+	flg.DurationVar(&timeout, "timeout", 0, "Stop do scripts that run longer than the duration, such as 10m.")
+
 	flg.BoolVar(&ignored, "old-args", false, "Ignored apenwarr redo compatibility flag")
 
 	cmdRedo.Flag = flg
@@ -94,6 +124,40 @@ func runRedo(targets []string) error {
 		redux.Debug = true
 	}
 
+	if jobs > 0 {
+		os.Setenv("REDO_JOBS", strconv.Itoa(jobs))
+		redux.Jobs = jobs
+	}
+
+	if timeout > 0 {
+		os.Setenv("REDO_TIMEOUT", timeout.String())
+	}
+
+	if keepGoing {
+		os.Setenv("REDO_KEEP_GOING", "true")
+		redux.KeepGoing = true
+	}
+
+	if events != "" {
+		os.Setenv(redux.EVENTS_ENV, events)
+	}
+
+	// Nested redo invocations may run in other directories.
+	if s := os.Getenv(redux.EVENTS_ENV); s != "" {
+		path, err := filepath.Abs(s)
+		if err != nil {
+			return err
+		}
+		os.Setenv(redux.EVENTS_ENV, path)
+		redux.Events = path
+	}
+
+	// Nested redo invocations are part of the same run.
+	if redux.RunID == "" {
+		redux.RunID = redux.NewRunID()
+		os.Setenv("REDO_RUN_ID", redux.RunID)
+	}
+
 	// If no argument is specified, use default target if its .do file exists.
 	// Otherwise, print usage and exit.
 	if len(targets) == 0 {
@@ -122,16 +186,142 @@ func runRedo(targets []string) error {
 	// It *is* slower to reinitialize for each target, but doing
 	// so guarantees that a single redo call with multiple targets that
 	// potentially have differing roots will work correctly.
-	for _, path := range targets {
-		file, err := redux.NewFile(wd, path)
+	files, err := newFiles(wd, targets)
+	if err != nil {
+		return err
+	}
+
+	redux.SetOptions(files[0].Config)
+
+	if planOnly {
+		return printPlan(wd, files)
+	}
+
+	// The top level redo collects the failures of the whole run.
+	var failureLog string
+	if redux.KeepGoing && os.Getenv(redux.FAILURES_ENV) == "" {
+		failureLog, err = redux.NewFailureLog()
 		if err != nil {
 			return err
 		}
-		file.SetTaskFlag(isTask)
-		if err := file.Redo(); err != nil {
+		defer os.Remove(failureLog)
+	}
+
+	// The top level redo writes the trace of the whole run.
+	if trace == "" {
+		trace = os.Getenv("REDO_TRACE")
+	}
+	if trace != "" && os.Getenv(redux.TRACE_ENV) == "" {
+		spanLog, err := redux.NewTraceLog()
+		if err != nil {
 			return err
 		}
+		defer writeTrace(trace, spanLog)
 	}
 
+	err = redux.RunJobs(len(files), func(i int) error {
+		file := files[i]
+		file.SetTaskFlag(isTask)
+		err := file.Redo()
+		if err != nil {
+			file.RecordFailure(err)
+		}
+		return err
+	})
+
+	if err == nil || failureLog == "" {
+		return err
+	}
+
+	return failureSummary(wd, failureLog, err)
+}
+
+// writeTrace converts the span log to a trace file and removes it.
+// The build has already completed, so a failure is reported but is not an error.
+func writeTrace(path string, spanLog string) {
+	defer os.Remove(spanLog)
+
+	spans, err := redux.ReadSpans(spanLog)
+	if err == nil {
+		err = redux.WriteTrace(path, spans)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot write trace to %s: %s\n", path, err)
+	}
+}
+
+// failureSummary returns an error that lists the failed targets in the failure log,
+// or err if the log is empty.
+func failureSummary(wd string, failureLog string, err error) error {
+	failures, readErr := redux.ReadFailures(failureLog)
+	if readErr != nil {
+		return readErr
+	} else if len(failures) == 0 {
+		return err
+	}
+
+	rel := func(path string) string {
+		if s, err := filepath.Rel(wd, path); err == nil {
+			return s
+		}
+		return path
+	}
+
+	noun := "targets"
+	if len(failures) == 1 {
+		noun = "target"
+	}
+
+	lines := []string{fmt.Sprintf("%d %s failed:", len(failures), noun)}
+	for _, failure := range failures {
+		doFile := "no do file"
+		if failure.DoFile != "" {
+			doFile = rel(failure.DoFile)
+		}
+		lines = append(lines, fmt.Sprintf("  %s (%s): %s", rel(failure.Target), doFile, failure.Error))
+	}
+
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// printPlan prints the do scripts that would run to build the files, in build order, and the reason for each.
+func printPlan(wd string, files []*redux.File) error {
+	steps, err := redux.Plan(files...)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		doFile, err := filepath.Rel(wd, step.DoFile)
+		if err != nil {
+			doFile = step.DoFile
+		}
+		fmt.Printf("%s: %s\n", doFile, step.Outdated)
+	}
+
+	fmt.Println("# Prerequisites are those recorded by the last build. Do scripts that run may require others.")
+
 	return nil
 }
+
+// newFiles returns a File for each path, relative to dir.
+// Paths that refer to the same file are only included once, so that
+// parallel jobs never build the same target at the same time.
+func newFiles(dir string, paths []string) ([]*redux.File, error) {
+	seen := make(map[redux.Hash]bool)
+	files := make([]*redux.File, 0, len(paths))
+
+	for _, path := range paths {
+		file, err := redux.NewFile(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[file.FullPathHash] {
+			seen[file.FullPathHash] = true
+			files = append(files, file)
+		}
+	}
+
+	return files, nil
+}
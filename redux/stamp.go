@@ -0,0 +1,48 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdStamp = &Command{
+	Run:       runStamp,
+	UsageLine: "redux stamp",
+	LinkName:  "redo-stamp",
+	Short:     "Records a checksum of stdin as the stamp of the current target.",
+	Long: `
+The stamp command reads its standard input and records a checksum of the data
+as the stamp of the target being built. It should be run inside a do script.
+
+When a target has a stamp, its dependents consider it changed only when the stamp changes,
+regardless of the target's content. This allows a do script that always runs, typically to
+poll some external state, to avoid rebuilding its dependents when the state has not changed.
+
+    redo-always
+    git describe --always | tee $3 | redo-stamp
+`,
+}
+
+func runStamp(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v. Data is read from stdin", args)
+	}
+
+	target, err := parentFile()
+	if err != nil {
+		return err
+	}
+
+	stamp, err := redux.HashReader(os.Stdin, target.Config.Hash)
+	if err != nil {
+		return err
+	}
+
+	return target.PutStamp(stamp)
+}
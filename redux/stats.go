@@ -0,0 +1,146 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gyepisam/redux"
+)
+
+var cmdStats = &Command{
+	UsageLine: "redux stats [OPTIONS]",
+	Short:     "Reports build times.",
+	Long: `
+The stats command reports build statistics, which redo records each time it runs a do script.
+The report lists
+
+    the slowest targets, by the duration of their last build,
+    the most frequently rebuilt targets, by the number of builds, and
+    the total time spent running each do file in the recent builds of its targets.
+
+Each target's last ten builds are kept. Paths are relative to the redo root directory.
+`,
+}
+
+var statsCount int
+
+func init() {
+	// break loop
+	cmdStats.Run = runStats
+
+	flg := flag.NewFlagSet("stats", flag.ContinueOnError)
+	flg.IntVar(&statsCount, "n", 10, "Number of entries in each section.")
+	cmdStats.Flag = flg
+}
+
+func runStats(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	rootDir, found, err := redux.FindRootDir(wd)
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("cannot find redo root directory for %s", wd)
+	}
+
+	return redux.WithDB(rootDir, func(db redux.DB) error {
+		stats, err := redux.AllStats(db)
+		if err != nil {
+			return err
+		}
+
+		printStats(stats)
+		return nil
+	})
+}
+
+// A statsLine is an entry in a section of the report.
+type statsLine struct {
+	value string
+	name  string
+	sort  float64
+}
+
+type bySort []statsLine
+
+func (a bySort) Len() int      { return len(a) }
+func (a bySort) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySort) Less(i, j int) bool {
+	if a[i].sort != a[j].sort {
+		return a[i].sort > a[j].sort
+	}
+	return a[i].name < a[j].name
+}
+
+func printSection(title string, lines []statsLine) {
+	sort.Sort(bySort(lines))
+	if len(lines) > statsCount {
+		lines = lines[:statsCount]
+	}
+
+	fmt.Printf("%s:\n", title)
+	for _, line := range lines {
+		fmt.Printf("  %10s  %s\n", line.value, line.name)
+	}
+}
+
+func printStats(stats []redux.BuildStats) {
+	var slowest, rebuilt, doFiles []statsLine
+
+	type total struct {
+		duration time.Duration
+		builds   int
+	}
+	totals := make(map[string]*total)
+
+	for _, s := range stats {
+		last := s.Last()
+
+		name := s.Path
+		if last.Failed {
+			name += fmt.Sprintf(" (failed, status %d)", last.Status)
+		}
+		slowest = append(slowest, statsLine{formatDuration(last.Duration), name, last.Duration.Seconds()})
+
+		rebuilt = append(rebuilt, statsLine{fmt.Sprintf("%d", s.Builds), s.Path, float64(s.Builds)})
+
+		for _, build := range s.Recent {
+			t, ok := totals[build.DoFile]
+			if !ok {
+				t = new(total)
+				totals[build.DoFile] = t
+			}
+			t.duration += build.Duration
+			t.builds++
+		}
+	}
+
+	for doFile, t := range totals {
+		name := fmt.Sprintf("%s (%d builds)", doFile, t.builds)
+		doFiles = append(doFiles, statsLine{formatDuration(t.duration), name, t.duration.Seconds()})
+	}
+
+	printSection("Slowest targets", slowest)
+	fmt.Println()
+	printSection("Most rebuilt targets", rebuilt)
+	fmt.Println()
+	printSection("Time per do file", doFiles)
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
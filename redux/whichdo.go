@@ -0,0 +1,84 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gyepisam/fileutils"
+	"github.com/gyepisam/redux"
+)
+
+var cmdWhichDo = &Command{
+	Run:       runWhichDo,
+	UsageLine: "redux whichdo TARGET",
+	LinkName:  "redo-whichdo",
+	Short:     "Shows the do files that are searched to build a target.",
+	Long: `
+The whichdo command lists the do files that redo searches for in order to build TARGET,
+in search order, one per line. Each line shows whether the file exists, its path,
+and the $1 and $2 arguments and relative directory the do file would be run with.
+
+The first existing do file is the one that builds the target and is marked 'selected'.
+Later do files are marked 'exists' or 'missing'.
+
+The command exits with an error if there is no do file for the target.
+`,
+}
+
+func runWhichDo(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single TARGET argument")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	target, err := redux.NewFile(wd, args[0])
+	if err != nil {
+		return err
+	}
+
+	var selected *redux.DoInfo
+
+	for _, do := range target.DoFileSearchPath() {
+		exists, err := fileutils.FileExists(do.Path())
+		if err != nil {
+			return err
+		}
+
+		status := "missing"
+		if exists {
+			if selected == nil {
+				selected = do
+				status = "selected"
+			} else {
+				status = "exists"
+			}
+		}
+
+		path, err := filepath.Rel(wd, do.Path())
+		if err != nil {
+			return err
+		}
+
+		relDir := do.RelDir
+		if relDir == "" {
+			relDir = "."
+		}
+
+		fmt.Printf("%-8s %s\t$1=%s\t$2=%s\treldir=%s\n", status, path, do.RelPath(target.Name), do.RelPath(do.Arg2), relDir)
+	}
+
+	if selected == nil {
+		return fmt.Errorf("no do file found for %s", args[0])
+	}
+
+	return nil
+}
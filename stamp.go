@@ -0,0 +1,26 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+// A stamp is a checksum, provided by a do script through redo-stamp, that stands in
+// for the content of its target when determining whether the target has changed.
+// The stamp is recorded while the do script runs and is moved into the target's
+// metadata when the script completes.
+
+// PutStamp records the stamp for the target being built.
+func (f *File) PutStamp(stamp Hash) error {
+	return f.Put(f.stampKey(), stamp)
+}
+
+// GetStamp returns the stamp recorded for the target being built, if any.
+func (f *File) GetStamp() (stamp Hash, found bool, err error) {
+	found, err = f.Get(f.stampKey(), &stamp)
+	return
+}
+
+// DeleteStamp removes the stamp record.
+func (f *File) DeleteStamp() error {
+	return f.Delete(f.stampKey())
+}
@@ -16,3 +16,11 @@ func statUidGid(finfo os.FileInfo) (uint32, uint32, error) {
 	stat := sys.(*syscall.Stat_t)
 	return stat.Uid, stat.Gid, nil
 }
+
+// statInode returns the inode number of the file, or 0 if it is not available.
+func statInode(finfo os.FileInfo) uint64 {
+	if stat, ok := finfo.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
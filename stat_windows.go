@@ -10,3 +10,7 @@ import (
 func statUidGid(finfo os.FileInfo) (uint32, uint32, error) {
 	return 0, 0, errors.New("finfo.Sys() is unsupported")
 }
+
+func statInode(finfo os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,101 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// STATS_HISTORY is the number of recent builds kept in a target's statistics.
+const STATS_HISTORY = 10
+
+// A Build records a run of a target's do script.
+type Build struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Status   int           `json:"status"` // do script exit status, or -1 if it did not run to completion.
+	Failed   bool          `json:"failed"`
+	DoFile   string        `json:"do_file"` // relative to the root directory.
+}
+
+// BuildStats holds the build statistics of a target.
+// Builds and Failures count every build, while Recent only holds the last STATS_HISTORY builds, oldest first.
+type BuildStats struct {
+	Path     string  `json:"path"`
+	Builds   int     `json:"builds"`
+	Failures int     `json:"failures"`
+	Recent   []Build `json:"recent"`
+}
+
+// Last returns the most recent build.
+func (s *BuildStats) Last() Build {
+	return s.Recent[len(s.Recent)-1]
+}
+
+func (f *File) statsKey() string {
+	return f.makeKey("STATS")
+}
+
+// GetStats returns the target's build statistics.
+func (f *File) GetStats() (stats BuildStats, found bool, err error) {
+	found, err = f.Get(f.statsKey(), &stats)
+	return
+}
+
+// PutBuild adds the build to the target's statistics.
+func (f *File) PutBuild(build Build) error {
+	stats, _, err := f.GetStats()
+	if err != nil {
+		return err
+	}
+
+	stats.Path = f.Path
+	stats.Builds++
+	if build.Failed {
+		stats.Failures++
+	}
+
+	stats.Recent = append(stats.Recent, build)
+	if n := len(stats.Recent); n > STATS_HISTORY {
+		stats.Recent = stats.Recent[n-STATS_HISTORY:]
+	}
+
+	return f.Put(f.statsKey(), stats)
+}
+
+// DeleteStats removes the target's build statistics.
+func (f *File) DeleteStats() error {
+	return f.Delete(f.statsKey())
+}
+
+// AllStats returns the build statistics for every target in the database.
+func AllStats(db DB) ([]BuildStats, error) {
+	records, err := db.GetAllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := KEY_SEPARATOR + "STATS"
+
+	var out []BuildStats
+	for _, rec := range records {
+		if !strings.HasSuffix(rec.Key, suffix) {
+			continue
+		}
+
+		var stats BuildStats
+		if err := json.Unmarshal(rec.Value, &stats); err != nil {
+			return nil, err
+		}
+
+		if len(stats.Recent) > 0 {
+			out = append(out, stats)
+		}
+	}
+
+	return out, nil
+}
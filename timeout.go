@@ -0,0 +1,77 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// KILL_DELAY is the time a do script run by the top level redo process has to exit after it is asked
+// to terminate, whether because it timed out or because redo was interrupted. Then it is killed.
+const KILL_DELAY = 5 * time.Second
+
+// killDelay returns the time the do scripts run by this process have to exit after they are asked to terminate.
+// Nested redo processes are asked to terminate along with the do script that runs them, so the delay is halved
+// at each level to allow them to kill their own scripts, and remove their temporary outputs, before they are killed.
+func killDelay() time.Duration {
+	return KILL_DELAY >> uint(envInt("REDO_DEPTH", 0))
+}
+
+// A TimeoutError is returned when a do script runs longer than its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("do script timed out after %s", e.Timeout)
+}
+
+// runScript runs the command in a process group of its own and waits for it to complete.
+// If the command runs longer than timeout, its process group is sent SIGTERM and, if it is still running
+// after the kill delay, SIGKILL. A timeout of zero means no limit.
+// If redo is interrupted, the signal is forwarded to the process group. See interrupt.go.
+func runScript(cmd *exec.Cmd, timeout time.Duration) error {
+	setProcessGroup(cmd)
+
+	if err := startScript(cmd); err != nil {
+		return err
+	}
+	defer stopScript(cmd)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-done:
+		if sig := interruptSignal(); sig != nil {
+			return &InterruptError{sig}
+		}
+		return err
+	case <-expired:
+	}
+
+	signalGroup(cmd, syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(killDelay()):
+		signalGroup(cmd, syscall.SIGKILL)
+		<-done
+	}
+
+	return &TimeoutError{Timeout: timeout}
+}
@@ -0,0 +1,154 @@
+// Copyright 2014 Gyepi Sam. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package redux
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gyepisam/fileutils"
+)
+
+// TRACE_ENV names the environment variable that holds the path of the file in which
+// the processes of a traced build record the do scripts they run.
+const TRACE_ENV = "REDO_TRACE_SPANS"
+
+// A Span records a do script execution.
+type Span struct {
+	Target   string        `json:"target"` // full path to the target.
+	DoFile   string        `json:"do_file"`
+	Parent   string        `json:"parent"` // REDO_PARENT of the redo process, relative to its parent's do file.
+	Depth    int           `json:"depth"`
+	Command  string        `json:"command"` // name of the redo command that ran the script.
+	PID      int           `json:"pid"`     // redo process.
+	ShellPID int           `json:"shell_pid"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Status   int           `json:"status"`
+}
+
+// NewTraceLog creates an empty span log and sets TRACE_ENV so that the current process
+// and its children record their do script executions in it. It returns the path to the log.
+func NewTraceLog() (string, error) {
+	return newTempLog("redo-trace-", TRACE_ENV)
+}
+
+// recordSpan appends the span to the span log, if any.
+// A failure to record the span is reported, but does not affect the build.
+func recordSpan(span Span) {
+	path := os.Getenv(TRACE_ENV)
+	if path == "" {
+		return
+	}
+
+	if err := appendLine(path, span); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: cannot record trace in %s: %s\n", path, err)
+	}
+}
+
+// ReadSpans returns the spans in the log at path, ordered by start time.
+func ReadSpans(path string) ([]Span, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out []Span
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span Span
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			return nil, err
+		}
+		out = append(out, span)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(spansByStart(out))
+
+	return out, nil
+}
+
+type spansByStart []Span
+
+func (a spansByStart) Len() int           { return len(a) }
+func (a spansByStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a spansByStart) Less(i, j int) bool { return a[i].Start.Before(a[j].Start) }
+
+// traceEvent is an event in the Chrome Trace Event Format.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"` // microseconds
+	Dur  int64                  `json:"dur"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteTrace writes the spans to path in the Chrome Trace Event Format, which can be loaded in
+// chrome://tracing or Perfetto. Each do script is a complete event on a thread of its own,
+// numbered for the shell process, within the redo process that ran it. Processes are sorted by depth,
+// so the top level build comes first and nested builds follow.
+func WriteTrace(path string, spans []Span) error {
+	events := []traceEvent{}
+
+	processes := make(map[int]bool)
+
+	for _, span := range spans {
+		if !processes[span.PID] {
+			processes[span.PID] = true
+
+			name := span.Command
+			if span.Parent != "" {
+				name += " (" + span.Parent + ")"
+			}
+
+			events = append(events,
+				traceEvent{Name: "process_name", Ph: "M", Pid: span.PID, Args: map[string]interface{}{"name": name}},
+				traceEvent{Name: "process_sort_index", Ph: "M", Pid: span.PID, Args: map[string]interface{}{"sort_index": span.Depth}})
+		}
+
+		events = append(events, traceEvent{
+			Name: filepath.Base(span.Target),
+			Cat:  "redo",
+			Ph:   "X",
+			Ts:   span.Start.UnixNano() / int64(time.Microsecond),
+			Dur:  int64(span.Duration / time.Microsecond),
+			Pid:  span.PID,
+			Tid:  span.ShellPID,
+			Args: map[string]interface{}{
+				"target":  span.Target,
+				"do_file": span.DoFile,
+				"depth":   span.Depth,
+				"status":  span.Status,
+			},
+		})
+	}
+
+	b, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+	if err != nil {
+		return err
+	}
+
+	return fileutils.AtomicWrite(path, func(tmpFile *os.File) error {
+		_, err := tmpFile.Write(append(b, '\n'))
+		return err
+	})
+}
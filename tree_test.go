@@ -5,7 +5,10 @@
 package redux
 
 import (
+	"fmt"
 	"math/rand"
+	"os"
+	"os/exec"
 	"strings"
 	"testing"
 )
@@ -16,6 +19,8 @@ import (
 // This test verifies that despite the multiple invocations, each node is built just once.
 // The test also varies the dependency orderings to ensure that the result does not depend
 // in some particularly auspicious order.
+// The parallel ordering builds all of a node's prerequisites at once, so that concurrent
+// redo-ifchange processes compete to build the same nodes.
 func TestDeepTree(t *testing.T) {
 	const N = 10 // creates a tree with N nodes and N * (N - 1) / 2 dependencies
 	const out = "1"
@@ -41,7 +46,7 @@ printf "%d" $value
 		tail[i] = "cat " + name
 	}
 
-	for _, order := range []string{"forward", "reverse", "shuffle"} {
+	for _, order := range []string{"forward", "reverse", "shuffle", "parallel"} {
 		for k := 0; k < N; k++ {
 			tmp := head[k+1 : N] //each node depends on all succeeding nodes.
 			head0 := make([]string, len(tmp))
@@ -66,6 +71,14 @@ printf "%d" $value
 					head0[i], head0[j] = head0[j], head0[i]
 					tail0[i], tail0[j] = tail0[j], tail0[i]
 				}
+			case "parallel":
+				if len(head0) > 0 {
+					names := make([]string, len(head0))
+					for i, line := range head0 {
+						names[i] = strings.TrimPrefix(line, "redo-ifchange ")
+					}
+					head0 = []string{"redo-ifchange " + strings.Join(names, " ")}
+				}
 			default:
 				panic("unknown order: " + order)
 			}
@@ -79,7 +92,13 @@ printf "%d" $value
 		// One could also use a counting argument: 1 node produces 1, 2 -> 2, 3 -> 4, 4 -> 8, etc.
 		tree[0].Out = strings.Repeat(out, 1<<uint(len(tree)-1))
 		t.Logf("DeepTree order: %s\n", order)
-		SimpleTree(t, tree...)
+		if order == "parallel" {
+			os.Setenv("REDO_JOBS", "4")
+			SimpleTree(t, tree...)
+			os.Unsetenv("REDO_JOBS")
+		} else {
+			SimpleTree(t, tree...)
+		}
 	}
 }
 
@@ -172,3 +191,77 @@ chown :$grp $3
 `
 	SimpleTree(t, s0, s1)
 }
+
+// Independent targets built in parallel should each be built just once,
+// even when a target is named more than once on the command line.
+func TestParallelTargets(t *testing.T) {
+	const N = 8
+
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	if err := dir.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-j", "4"}
+
+	for i := 0; i < N; i++ {
+		s := Script{Name: fmt.Sprintf("T%d", i)}
+		s.Command = `
+value=1
+if test -e $1 ; then
+value=$(expr $(cat $1) + 1)
+fi
+sleep 0.1
+printf "%d" $value
+`
+		if err := s.Write(dir.path); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, s.Name, "./"+s.Name)
+	}
+
+	cmd := exec.Command("redo", args...)
+	cmd.Dir = dir.path
+
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	for i := 0; i < N; i++ {
+		CheckFileContent(t, dir.Append(fmt.Sprintf("T%d", i)), "1")
+	}
+}
+
+// A make process run by a do script shares the redo job server and runs its jobs in parallel.
+func TestJobServer(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not found")
+	}
+
+	dir, err := newDir(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Cleanup()
+
+	makefile := "all: a b\n\na b:\n\t@echo start; sleep 0.5; echo end\n"
+	if err := dir.WriteFile("Makefile", makefile); err != nil {
+		t.Fatal(err)
+	}
+
+	s := Script{Name: "A", Out: "start\nstart\nend\nend\n", Command: "make -s 2>&1\n"}
+
+	cmd := dir.Command(s)
+	cmd.Args = append(cmd.Args[:1], append([]string{"-j", "2"}, cmd.Args[1:]...)...)
+
+	if result := run(t, cmd); result.Err != nil {
+		t.Fatal(result)
+	}
+
+	s.CheckOutput(t, dir.path)
+}
@@ -6,13 +6,52 @@ package redux
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
 )
 
+// Content hash algorithms.
+const (
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+
+	// DEFAULT_HASH names the algorithm used to compute content hashes.
+	// Its hashes are not prefixed with the algorithm name, for compatibility
+	// with the records of earlier versions.
+	DEFAULT_HASH = SHA1
+
+	// HASH_SEPARATOR separates the algorithm name from the hash value.
+	HASH_SEPARATOR = ":"
+)
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	SHA1:   sha1.New,
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+}
+
+// HashAlgorithms returns the names of the supported content hash algorithms.
+func HashAlgorithms() []string {
+	var names []string
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type Hash string
 
+// MakeHash returns the SHA1 hash of content. It is used for database keys
+// and is unaffected by the content hash algorithm.
 func MakeHash(content interface{}) Hash {
 
 	hash := sha1.New()
@@ -29,10 +68,48 @@ func MakeHash(content interface{}) Hash {
 	return Hash(hex.EncodeToString(hash.Sum(nil)))
 }
 
-func ContentHash(path string) (hash Hash, err error) {
-	b, err := ioutil.ReadFile(path)
-	if err == nil {
-		hash = MakeHash(b)
+// Algorithm returns the name of the algorithm that computed the hash.
+func (h Hash) Algorithm() string {
+	if i := strings.Index(string(h), HASH_SEPARATOR); i > -1 {
+		return string(h[:i])
+	}
+	return DEFAULT_HASH
+}
+
+// HashReader returns the hash, computed with the named algorithm, of the data read from r.
+// Hashes computed with algorithms other than DEFAULT_HASH are prefixed with the algorithm name.
+func HashReader(r io.Reader, algorithm string) (Hash, error) {
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unknown hash algorithm: %s", algorithm)
 	}
-	return
+
+	hash := newHash()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+
+	value := hex.EncodeToString(hash.Sum(nil))
+	if algorithm != DEFAULT_HASH {
+		value = algorithm + HASH_SEPARATOR + value
+	}
+
+	return Hash(value), nil
+}
+
+// ContentHash returns the hash of the file contents, computed with the default algorithm.
+func ContentHash(path string) (Hash, error) {
+	return FileHash(path, DEFAULT_HASH)
+}
+
+// FileHash returns the hash of the file contents, computed with the named algorithm.
+// The file is read in chunks, so its size is not limited by available memory.
+func FileHash(path string, algorithm string) (Hash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return HashReader(file, algorithm)
 }